@@ -0,0 +1,147 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Virtual clock. Time only passes when Advance is called, firing the timers
+// (and waking up the sleepers) that are due, in order.
+type Fake struct {
+	now    time.Time
+	timers []*fakeTimer
+
+	mu      sync.Mutex
+	changed *sync.Cond // Broadcast whenever timers change
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{
+		clock: f,
+		when:  f.now.Add(d),
+		c:     make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.c <- f.now
+		return t
+	}
+
+	f.timers = append(f.timers, t)
+	f.changed.Broadcast()
+	return t
+}
+
+// Move the clock forward by d, firing every timer that's due on the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+	for len(f.timers) > 0 {
+		t := f.next()
+		if t.when.After(end) {
+			break
+		}
+		f.now = t.when
+		f.fire(t)
+	}
+	f.now = end
+}
+
+// Move the clock forward to the next timer and fire it, along with any other
+// due at the same time. Returns false if there are no timers pending.
+func (f *Fake) AdvanceToNext() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.timers) == 0 {
+		return false
+	}
+	f.now = f.next().when
+	for len(f.timers) > 0 && !f.next().when.After(f.now) {
+		f.fire(f.next())
+	}
+	return true
+}
+
+// Number of pending timers, including sleepers.
+func (f *Fake) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// Block until there are at least n pending timers, which is to say that n
+// goroutines are (likely) waiting on the clock.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.changed.Wait()
+	}
+}
+
+// Earliest pending timer; the lock must be held and there must be timers.
+func (f *Fake) next() *fakeTimer {
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].when.Before(f.timers[j].when)
+	})
+	return f.timers[0]
+}
+
+// Fire a pending timer; the lock must be held.
+func (f *Fake) fire(t *fakeTimer) {
+	f.remove(t)
+	t.c <- f.now
+}
+
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i := range f.timers {
+		if f.timers[i] == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			f.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *Fake
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
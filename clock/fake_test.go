@@ -0,0 +1,54 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeFiresTimersInOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	f := NewFake(start)
+
+	t1 := f.NewTimer(2 * time.Second)
+	t2 := f.NewTimer(time.Second)
+	t3 := f.NewTimer(3 * time.Second)
+	if !t3.Stop() {
+		t.Error("expected to stop a pending timer")
+	}
+
+	f.Advance(2500 * time.Millisecond)
+
+	if at := <-t2.C(); !at.Equal(start.Add(time.Second)) {
+		t.Errorf("expected first timer at 1s, got %v", at.Sub(start))
+	}
+	if at := <-t1.C(); !at.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("expected second timer at 2s, got %v", at.Sub(start))
+	}
+	if now := f.Since(start); now != 2500*time.Millisecond {
+		t.Errorf("expected clock at 2.5s, got %v", now)
+	}
+	if f.Timers() != 0 {
+		t.Errorf("expected no pending timers, got %d", f.Timers())
+	}
+	if f.AdvanceToNext() {
+		t.Error("expected no timer to advance to")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+
+	done := make(chan time.Time)
+	go func() {
+		f.Sleep(time.Hour)
+		done <- f.Now()
+	}()
+
+	f.BlockUntil(1)
+	if !f.AdvanceToNext() {
+		t.Fatal("expected a sleeper to advance to")
+	}
+	if at := <-done; !at.Equal(time.Unix(3600, 0)) {
+		t.Errorf("expected to wake up after an hour, got %v", at)
+	}
+}
@@ -1,39 +1,92 @@
 package control
 
 import (
+	"context"
 	"math"
 	"time"
 
 	"github.com/Lowercases/queue-scaling/ema"
 )
 
+// Whatever's scaling the workers of a plant, e.g. an ECS or Kubernetes manager.
+// Managers for real queues take one to set beta on and query it.
+type Actuator interface {
+	SetB() chan float64
+	Beta() (uint, error)
+}
+
+// A sample of the plant's state.
+type Observation struct {
+	DX, DY float64 // Input and output rates, per unit
+	XmY    uint    // X - Y, messages in the system
+	Q      uint    // Messages queued up
+	Beta   uint    // Workers running
+
+	// Most workers the plant can make use of, e.g. partitions of a Kafka
+	// topic; beta is capped to it. Zero for no limit.
+	MaxBeta uint
+
+	// Rate of failed attempts, per unit: messages taken by the workers but
+	// not completed, that are queued up again to be retried or moved to a
+	// dead-letter queue. They take up workers without making it to DY. Zero
+	// if unknown.
+	DR float64
+
+	// Messages in the dead-letter queue, if any.
+	DeadLetters uint
+
+	// Time messages spend queued up, if the plant tracks it.
+	Age *QueueAge
+
+	// Workers requested from the actuator, and those requested that aren't
+	// running yet, e.g. still being provisioned. Zero if the actuator
+	// doesn't report them, see PendingActuator.
+	Desired, Pending uint
+}
+
+// Time messages spend queued up, in units.
+type QueueAge struct {
+	// Age of the oldest message queued up, zero if there's none.
+	Oldest float64 `json:"oldest"`
+
+	// Time queued up of the messages taken by the workers since the last
+	// sample, at Percentile (e.g. 0.95). Zero Percentile if unknown.
+	Latency    float64 `json:"latency"`
+	Percentile float64 `json:"percentile"`
+}
+
 type Manager interface {
 	SetB() chan float64
 
-	DXY(unit time.Duration) (float64, float64)
-	XmY() uint // X - Y
-	Q() uint
-	Beta() uint
+	// Sample the plant, with rates given per unit. Errors are expected to be
+	// transient; the controller keeps the plant as it is and retries.
+	Sample(unit time.Duration) (Observation, error)
 
 	// Optional for the plant since it can be got from Little's Law
 	MuP() (float64, bool)
 }
 
+// The model-based controller: estimates the throughput per worker R from the
+// plant, and sets the workers needed for the arrival rate, plus those needed to
+// drain the queue in the max queue time.
 type Control struct {
-	plant Manager
-
-	dryRun bool
+	loop
 
 	// Settings
-	t    uint
-	mq   uint
-	unit time.Duration
+	mq uint
 
 	// Queryable state
 	dx, dy  float64 // derivatives (integral differences)
+	dr      float64 // failed attempts
 	r, b, k float64 // measured R and b and k setpoints
 	xd      uint    // expected messages in the system
 
+	// Last successful observation
+	obs Observation
+
+	// Whether the first iteration has been run
+	started bool
+
 	// Beta integral and y estimation
 	y, betaIntegral *ema.EMA
 
@@ -42,14 +95,18 @@ type Control struct {
 
 	// Internal concurrency (for diagnostics)
 	internalConcurrency *ema.EMA
+
+	// Costs to minimise instead of matching the arrival rate, if any
+	costs *costModel
+
+	// Time messages should spend queued up, if set, on top of mq
+	slo uint
 }
 
 func NewControl(plant Manager, controlPeriod, maxQueueTime uint, unit time.Duration) *Control {
 	return &Control{
-		plant:               plant,
-		t:                   controlPeriod,
+		loop:                newLoop(plant, controlPeriod, unit),
 		mq:                  maxQueueTime,
-		unit:                unit,
 		betaEMA:             ema.NewEMA(1),
 		y:                   ema.NewEMI(100),
 		betaIntegral:        ema.NewEMI(100),
@@ -57,10 +114,6 @@ func NewControl(plant Manager, controlPeriod, maxQueueTime uint, unit time.Durat
 	}
 }
 
-func (c *Control) SetDryRun() {
-	c.dryRun = true
-}
-
 func (c *Control) SetEMASize(size int) {
 	c.betaEMA = ema.NewEMA(size)
 }
@@ -70,187 +123,330 @@ func (c *Control) SetEMISize(size int) {
 	c.betaIntegral = ema.NewEMI(size)
 }
 
-func (c *Control) Run() {
-	firstIteration := true
-
-	for {
-		time.Sleep(time.Duration(c.t) * c.unit)
-
-		c.dx, c.dy = c.plant.DXY(c.unit)
-		B := c.plant.Beta()
-		Q := c.plant.Q()
-		W := c.plant.XmY() - Q
-
-		// Integrate beta and y. Practically speaking, in order to integrate
-		// them we should multiply by the period; but since they are always used
-		// as a ratio y / betaIntegral or compared against 0, we can avoid that.
-		c.y.Add(c.dy)                  // * float64(c.t)
-		c.betaIntegral.Add(float64(B)) // * float64(c.t)
-
-		if c.y.Value()*float64(c.t) < 1 || c.betaIntegral.Value() < 1 {
-			// The system hasn't started yet. This is an arbitrary sane choice,
-			// since we've got no point of reference -- we'll leave it alone if
-			// greater than 0, and set it to 1 if there's data but it's scaled
-			// to 0.
-			if Q+W == 0 {
-				// The system isn't receiving messages, so it's safe to stop it
-				// or keep it stopped.
-				c.b = 0
-			} else if B > 0 {
-				c.b = float64(B)
-			} else {
-				// Arbitrary choice. The system should self-correct as it learns
-				// its processing rate.
-				c.b = 1
-			}
+// Run the control loop until ctx is cancelled. The reason it stopped is
+// returned, after sending the shutdown beta (if any) to the plant, or
+// ErrShutdownBeta if the plant doesn't take it within the shutdown timeout.
+func (c *Control) Run(ctx context.Context) error {
+	return c.run(ctx, c.Step)
+}
 
-		} else { // X >= Y > 0
-			// The system is operating with a non-zero input and output rate.
-			// Compute the output throughput R, using the highest between
-			// instant throughput (y-dot/beta) and historic (y/B), since the
-			// latter is less exact but good when beta is close to zero, when
-			// the workers are very likely to be starved.
-			R := c.y.Value() / c.betaIntegral.Value()
-			if B > 0 {
-				RI := c.dy / float64(B)
-				if RI > R {
-					R = RI
-				}
-			}
+// Run a single iteration of the control loop, sampling the plant and
+// computing the beta to be set, if any. Run calls it every control period;
+// a simulation can call it directly and set beta itself.
+// If sampling fails, the error is returned, with the failsafe beta to be set
+// if the failure threshold has just been reached.
+func (c *Control) Step() (beta float64, set bool, err error) {
+	return c.step(c.update)
+}
+
+// Update the state from a new observation, returning the decision taken and
+// whether beta should be set. Must be called with the lock held.
+func (c *Control) update(obs Observation) (Decision, bool) {
+	c.obs = obs
+	c.dx, c.dy, c.dr = obs.DX, obs.DY, obs.DR
+	B := obs.Beta
+	Q := obs.Q
+	W := obs.XmY - Q
+
+	// Failed attempts are work for the workers: they come back as input to
+	// be retried, and took as long to process as the successful ones. The
+	// law below works on the attempts, so that R is the worker's throughput
+	// rather than its useful throughput.
+	dx, dy := c.dx+c.dr, c.dy+c.dr
+
+	// Integrate beta and y. Practically speaking, in order to integrate
+	// them we should multiply by the period; but since they are always used
+	// as a ratio y / betaIntegral or compared against 0, we can avoid that.
+	c.y.Add(dy)                    // * float64(c.t)
+	c.betaIntegral.Add(float64(B)) // * float64(c.t)
+
+	var branch Branch
+	if c.y.Value()*float64(c.t) < 1 || c.betaIntegral.Value() < 1 {
+		branch = ColdStart
+		// The system hasn't started yet. This is an arbitrary sane choice,
+		// since we've got no point of reference -- we'll leave it alone if
+		// greater than 0, and set it to 1 if there's data but it's scaled
+		// to 0.
+		if Q+W == 0 {
+			// The system isn't receiving messages, so it's safe to stop it
+			// or keep it stopped.
+			c.b = 0
+		} else if B > 0 {
+			c.b = float64(B)
+		} else {
+			// Arbitrary choice. The system should self-correct as it learns
+			// its processing rate.
+			c.b = 1
+		}
 
-			// Save internal concurrency.
-			if B > 0 {
-				c.internalConcurrency.Add(float64(W) / float64(B))
+	} else { // X >= Y > 0
+		// The system is operating with a non-zero input and output rate.
+		// Compute the output throughput R, using the highest between
+		// instant throughput (y-dot/beta) and historic (y/B), since the
+		// latter is less exact but good when beta is close to zero, when
+		// the workers are very likely to be starved.
+		R := c.y.Value() / c.betaIntegral.Value()
+		if B > 0 {
+			RI := dy / float64(B)
+			if RI > R {
+				R = RI
 			}
+		}
 
-			if Q > B {
-				// Q > 0 (considering Q <= beta as insignificant, as in high
-				// traffic it might be difficult to spot an actual 0) means the
-				// system is queued up so just use a y-dot estimation of the
-				// rate since workers are operating at full speed.
-				c.r = R
-				c.b = c.dx / R
-				c.k = float64(Q) / R / float64(c.mq)
-
-			} else if W > 0 {
-				// The system is either overscaled or in equilibrium. Use the
-				// mean between the two rate estimations, in order to bring the
-				// lower bound of the rate estimation (obtained though
-				// controlling beta) up towards the equilibrium value, given by
-				// Little's Theorem.
-				// Why not using Little's Theorem right away? To avoid flapping.
-				//
-				// In our use of the Little's Theorem, we want to know the
-				// number of active workers (to know if this is lesser than β,
-				// i.e. some are starving). If the workers aren't internally
-				// concurrent, this means W is the number of active workers;
-				// however for internally concurrent workers this isn't true,
-				// we might have a very high W meaning many messages are being
-				// processed by the system, while the number of busy workers is
-				// still low.
-				// In order to have a good estimation of this, we keep an
-				// internal concurrency average from samples from when the
-				// system is queued up (Q > 0), which should mean that the
-				// system is showing its internal concurrency in W / β.
-				// We now use that to estimate number of active workers -- only
-				// if we know this number is above 1, i.e. we have confirmed
-				// internal concurrency.
+		// Save internal concurrency.
+		if B > 0 {
+			c.internalConcurrency.Add(float64(W) / float64(B))
+		}
+
+		if Q > B {
+			branch = Queued
+			// Q > 0 (considering Q <= beta as insignificant, as in high
+			// traffic it might be difficult to spot an actual 0) means the
+			// system is queued up so just use a y-dot estimation of the
+			// rate since workers are operating at full speed.
+			c.r = R
+			c.b = dx / R
+			// Drain the queue there'd be with the workers pending
+			// running, which those requested for it are.
+			c.k = c.deadTime.backlog(Q, R, c.t) / R / float64(c.mq)
+
+		} else if W > 0 {
+			branch = Overscaled
+			// The system is either overscaled or in equilibrium. Use the
+			// mean between the two rate estimations, in order to bring the
+			// lower bound of the rate estimation (obtained though
+			// controlling beta) up towards the equilibrium value, given by
+			// Little's Theorem.
+			// Why not using Little's Theorem right away? To avoid flapping.
+			//
+			// In our use of the Little's Theorem, we want to know the
+			// number of active workers (to know if this is lesser than β,
+			// i.e. some are starving). If the workers aren't internally
+			// concurrent, this means W is the number of active workers;
+			// however for internally concurrent workers this isn't true,
+			// we might have a very high W meaning many messages are being
+			// processed by the system, while the number of busy workers is
+			// still low.
+			// In order to have a good estimation of this, we keep an
+			// internal concurrency average from samples from when the
+			// system is queued up (Q > 0), which should mean that the
+			// system is showing its internal concurrency in W / β.
+			// We now use that to estimate number of active workers -- only
+			// if we know this number is above 1, i.e. we have confirmed
+			// internal concurrency.
+			// Without arrivals there's nothing to estimate from, so R and b
+			// are kept until the messages in flight are done with.
+			if dx > 0 {
 				busyWorkers := float64(W)
 				if c.internalConcurrency.Value() > 1.0 {
 					busyWorkers /= c.internalConcurrency.Value()
 				}
 
-				yBInv := R / c.dx
+				yBInv := R / dx
 				xBInv := 1.0 / busyWorkers
 				// Harmonic mean to discard overscaled values
 				c.b = 2.0 / (xBInv + yBInv)
-				c.r = c.dx / c.b
-				c.k = 0
-
-			} else { // X = Y
-				// The system has stopped, or it's processing messages too
-				// quickly to be able to observe it. Repeat the calculations
-				// from above but instead of using the harmonic mean (which
-				// would lead b to be 0 always), use the arithmetic mean -- this
-				// is, appoint half the workers we'd have if we keep this R.
-				// This should eventually bring R closer to reality if it's
-				// underestimated (which would be the case for a controller that
-				// is started in a system that's already overscaled).
-				// We also can't use Little Theorem's, so just keep the computed
-				// R.
-				c.b = c.dx / R / 2 // Arithmetic mean with X-Y =0.
-				c.r = R
-				c.k = 0
+				c.r = dx / c.b
 			}
+			c.k = 0
+
+		} else { // X = Y
+			branch = Idle
+			// The system has stopped, or it's processing messages too
+			// quickly to be able to observe it. Repeat the calculations
+			// from above but instead of using the harmonic mean (which
+			// would lead b to be 0 always), use the arithmetic mean -- this
+			// is, appoint half the workers we'd have if we keep this R.
+			// This should eventually bring R closer to reality if it's
+			// underestimated (which would be the case for a controller that
+			// is started in a system that's already overscaled).
+			// We also can't use Little Theorem's, so just keep the computed
+			// R.
+			c.b = dx / R / 2 // Arithmetic mean with X-Y =0.
+			c.r = R
+			c.k = 0
 		}
 
-		if firstIteration {
-			// Don't set beta the first iteration since the system hasn't had
-			// time to integrate.
-			firstIteration = false
-			continue
+		// With a cost model, b is whatever's cheapest at the rate
+		// estimated. Not while idle, where b is meant to probe for R.
+		if c.costs != nil && branch != Idle {
+			c.b = c.costs.optimal(dx, c.r, c.mq, c.unit)
 		}
 
-		// c.k is bursty, we allow it to rapidly change.
-		c.betaEMA.Add(c.b)
-
-		if c.dryRun {
-			continue
+		if c.slo > 0 && obs.Age != nil {
+			c.applySLO(*obs.Age, Q, branch)
 		}
+	}
 
-		// Set b
-		c.plant.SetB() <- c.betaEMA.Value() + c.k
+	d := Decision{
+		DX:          c.dx,
+		DY:          c.dy,
+		Q:           Q,
+		W:           W,
+		B:           B,
+		MaxBeta:     obs.MaxBeta,
+		Pending:     c.pending,
+		DR:          c.dr,
+		DeadLetters: obs.DeadLetters,
+		Age:         obs.Age,
+		Branch:      branch,
+		R:           c.r,
+		Bh:          c.b,
+		K:           c.k,
+	}
 
+	if !c.started {
+		// Don't set beta the first iteration since the system hasn't had
+		// time to integrate.
+		c.started = true
+		d.Beta = c.beta()
+		d.Cost = c.cost()
+		return d, false
 	}
 
+	// c.k is bursty, we allow it to rapidly change.
+	c.betaEMA.Add(c.b)
+
+	d.Beta = c.beta()
+	d.Cost = c.cost()
+	return d, true
+
 }
 
+// The getters below are safe to call while the controller runs, but each
+// takes the lock separately; use Snapshot for a consistent view.
+
 func (c *Control) XD() uint {
-	if c.dx > 0 {
-		return uint(math.Round(c.MuP() / 1000 * c.dx))
-	}
-	return 0
+	mu_p, ok := c.plant.MuP()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.expected(mup(c.obs, mu_p, ok))
 }
 
 func (c *Control) DX() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.dx
 }
 
 func (c *Control) DY() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.dy
 }
 
+// Rate of failed attempts, per unit.
+func (c *Control) DR() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dr
+}
+
+// Fraction of the attempts to process messages that failed.
+func (c *Control) FailureRate() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.failureRate()
+}
+
 func (c *Control) R() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.r
 }
 
 func (c *Control) B() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.b
 }
 
 func (c *Control) K() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.k
 }
 
 func (c *Control) Beta() float64 {
-	return c.betaEMA.Value() + c.k
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.beta()
 }
 
 func (c *Control) MuP() float64 {
 	mu_p, ok := c.plant.MuP()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return mup(c.obs, mu_p, ok)
+}
+
+// Projected cost per hour of beta, if a cost model is set.
+func (c *Control) Cost() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cost()
+}
+
+func (c *Control) InternalConcurrency() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.internalConcurrency.Value()
+}
+
+// Unlocked versions of the getters, the lock must be held.
+
+func (c *Control) beta() float64 {
+	beta := c.betaEMA.Value() + c.k
+	if c.obs.MaxBeta > 0 && beta > float64(c.obs.MaxBeta) {
+		beta = float64(c.obs.MaxBeta)
+	}
+	if c.costs != nil {
+		if max := c.costs.maxBeta(); max > 0 && beta > max {
+			beta = max
+		}
+	}
+	return beta
+}
+
+// Expected cost per hour of the workers beta rounds to, at the current rates.
+func (c *Control) cost() float64 {
+	if c.costs == nil {
+		return 0
+	}
+	beta := int(math.Round(c.beta()))
+	if c.r <= 0 {
+		return float64(beta) * c.costs.workerHour
+	}
+	return c.costs.cost(beta, c.dx+c.dr, c.r, c.mq, c.unit)
+}
+
+func (c *Control) failureRate() float64 {
+	if attempts := c.dy + c.dr; attempts > 0 {
+		return c.dr / attempts
+	}
+	return 0
+}
+
+func (c *Control) expected(mu_p float64) uint {
+	if c.dx > 0 {
+		return uint(math.Round(mu_p / 1000 * c.dx))
+	}
+	return 0
+}
+
+// MuP given what the plant reports, or else from the last observation.
+func mup(obs Observation, mu_p float64, ok bool) float64 {
 	if ok {
 		return mu_p
 	}
 
 	// Compute from Little's Law
-	if c.dx > 0 {
-		return float64(c.plant.XmY()) / c.dx
+	if obs.DX > 0 {
+		return float64(obs.XmY) / obs.DX
 	}
 
 	// No data
 	return 0
 }
-
-func (c *Control) InternalConcurrency() float64 {
-	return c.internalConcurrency.Value()
-}
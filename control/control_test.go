@@ -0,0 +1,366 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+)
+
+// Static plant, always reporting the same statistics.
+type fakeManager struct {
+	setB chan float64
+
+	// Errors returned by Sample, one per call, before succeeding
+	errs chan error
+
+	dx, dy, dr float64
+	xmy, q     uint
+	beta       uint
+	maxBeta    uint
+	mu_p       float64
+	mu_p_known bool
+	age        *QueueAge
+	pending    uint
+}
+
+func newFakeManager() *fakeManager {
+	return &fakeManager{
+		setB: make(chan float64),
+		errs: make(chan error, 16),
+		dx:   10,
+		dy:   10,
+		xmy:  30,
+		q:    20,
+		beta: 5,
+	}
+}
+
+func (m *fakeManager) SetB() chan float64 {
+	return m.setB
+}
+
+func (m *fakeManager) Sample(unit time.Duration) (Observation, error) {
+	select {
+	case err := <-m.errs:
+		return Observation{}, err
+	default:
+	}
+
+	return Observation{
+		DX:      m.dx,
+		DY:      m.dy,
+		DR:      m.dr,
+		XmY:     m.xmy,
+		Q:       m.q,
+		Beta:    m.beta,
+		MaxBeta: m.maxBeta,
+		Age:     m.age,
+		Desired: m.beta + m.pending,
+		Pending: m.pending,
+	}, nil
+}
+
+func (m *fakeManager) MuP() (float64, bool) {
+	return m.mu_p, m.mu_p_known
+}
+
+// Start the control loop, returning a function that stops it and returns the
+// error Run exited with.
+func start(c *Control) (stop func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestRunSetsBeta(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 10, time.Millisecond)
+	stop := start(c)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case b := <-m.setB:
+			if b <= 0 {
+				t.Errorf("expected a positive beta for a queued plant, got %v", b)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for beta")
+		}
+	}
+
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestRunSendsShutdownBeta(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 10, time.Millisecond)
+	c.SetDryRun()
+	c.SetShutdownBeta(3)
+	stop := start(c)
+
+	// Dry runs don't send anything, not even on shutdown.
+	errc := make(chan error)
+	go func() { errc <- stop() }()
+	select {
+	case b := <-m.setB:
+		t.Fatalf("unexpected beta %v in dry run", b)
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	}
+
+	c = NewControl(m, 1000, 10, time.Hour)
+	c.SetShutdownBeta(3)
+	stop = start(c)
+
+	go func() { errc <- stop() }()
+	if b := <-m.setB; b != 3 {
+		t.Errorf("expected shutdown beta 3, got %v", b)
+	}
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestRunGivesUpOnShutdownBeta(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1000, 10, time.Hour)
+	c.SetShutdownBeta(3)
+	c.SetShutdownTimeout(10 * time.Millisecond)
+	stop := start(c)
+
+	// Nobody reads the beta channel, as a plant stopped on the same context.
+	if err := stop(); !errors.Is(err, ErrShutdownBeta) {
+		t.Errorf("expected %v, got %v", ErrShutdownBeta, err)
+	}
+}
+
+func TestRunStopsWhilePlantIsBlocked(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 10, time.Millisecond)
+	stop := start(c)
+
+	// Nobody reads the beta channel, so the loop is stuck setting beta.
+	time.Sleep(20 * time.Millisecond)
+
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestRunFallsBackToFailsafe(t *testing.T) {
+	m := newFakeManager()
+	for i := 0; i < 4; i++ {
+		m.errs <- errors.New("throttled")
+	}
+
+	c := NewControl(m, 1, 10, time.Millisecond)
+	c.SetFailsafe(3, 7)
+	c.SetRetryBackoff(time.Millisecond, 2*time.Millisecond)
+	stop := start(c)
+	defer stop()
+
+	// The failsafe beta comes first, and only once.
+	if b := <-m.setB; b != 7 {
+		t.Errorf("expected failsafe beta 7, got %v", b)
+	}
+	if b := <-m.setB; b == 7 {
+		t.Errorf("expected control to resume after recovering, got failsafe beta")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := NewControl(newFakeManager(), 10, 10, time.Second)
+
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, e := range expected {
+		c.failures = uint(i + 1)
+		if d := c.backoff(); d != e*time.Second {
+			t.Errorf("%d failures: expected %v, got %v", c.failures, e*time.Second, d)
+		}
+	}
+}
+
+// Deterministic fluid model of a plant, driven by a virtual clock. Messages
+// arrive at a rate that follows a four hour cycle, and each worker processes
+// rate messages per second.
+type fluidPlant struct {
+	setB  chan float64
+	clock *clock.Fake
+	last  time.Time
+
+	q, busy float64
+	beta    uint
+	rate    float64
+}
+
+func (p *fluidPlant) SetB() chan float64 {
+	return p.setB
+}
+
+func (p *fluidPlant) arrivals(t time.Time) float64 {
+	return 5 + 4*math.Sin(2*math.Pi*float64(t.Unix())/(4*3600))
+}
+
+func (p *fluidPlant) Sample(unit time.Duration) (Observation, error) {
+	now := p.clock.Now()
+	dt := now.Sub(p.last).Seconds()
+	p.last = now
+
+	in := p.arrivals(now) * dt
+	out := math.Min(p.q+in, float64(p.beta)*p.rate*dt)
+	p.q += in - out
+	p.busy = out / dt / p.rate
+
+	perUnit := float64(unit) / float64(time.Second)
+	return Observation{
+		DX:   in / dt * perUnit,
+		DY:   out / dt * perUnit,
+		XmY:  uint(math.Round(p.q + p.busy)),
+		Q:    uint(math.Round(p.q)),
+		Beta: p.beta,
+	}, nil
+}
+
+func (p *fluidPlant) MuP() (float64, bool) {
+	return 1000 / p.rate, true
+}
+
+// Run the fluid plant for the given amount of virtual time, returning every
+// beta set by the controller.
+func runScenario(d time.Duration) []float64 {
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := &fluidPlant{
+		setB:  make(chan float64),
+		clock: clk,
+		last:  clk.Now(),
+		beta:  1,
+		rate:  0.5,
+	}
+
+	c := NewControl(p, 60, 120, time.Second)
+	c.SetClock(clk)
+	stop := start(c)
+	defer stop()
+
+	betas := []float64{}
+	for clk.Since(time.Unix(0, 0)) < d {
+		clk.BlockUntil(1)
+		clk.AdvanceToNext()
+
+		// The controller either sets beta, or goes back to sleep.
+		for clk.Timers() == 0 {
+			select {
+			case b := <-p.setB:
+				betas = append(betas, b)
+				p.beta = uint(math.Round(b))
+			default:
+				runtime.Gosched()
+			}
+		}
+	}
+	return betas
+}
+
+func TestScenarioWithVirtualClock(t *testing.T) {
+	betas := runScenario(12 * time.Hour)
+	if len(betas) != 12*60-1 {
+		t.Fatalf("expected a beta every minute but the first, got %d", len(betas))
+	}
+
+	// Arrivals range from 1 to 9 per second, at half a message per second
+	// per worker.
+	for i, b := range betas[60:] {
+		if b < 1 || b > 30 {
+			t.Errorf("minute %d: beta %v out of range", i+61, b)
+		}
+	}
+
+	again := runScenario(12 * time.Hour)
+	for i := range betas {
+		if betas[i] != again[i] {
+			t.Fatalf("minute %d: expected a reproducible beta %v, got %v", i+1, betas[i], again[i])
+		}
+	}
+}
+
+func TestSnapshotWhileRunning(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 10, time.Millisecond)
+	stop := start(c)
+
+	// Run under the race detector to check the getters too.
+	var last Snapshot
+	for i := 0; i < 5; {
+		select {
+		case <-m.setB:
+			i++
+		default:
+			s := c.Snapshot()
+			if s.Iteration < last.Iteration {
+				t.Fatalf("iterations went backwards, from %d to %d", last.Iteration, s.Iteration)
+			}
+			last = s
+			c.Beta()
+			c.XD()
+		}
+	}
+	stop()
+
+	s := c.Snapshot()
+	if s.Iteration < 6 || s.Branch != Queued || s.Observation.Q != m.q || s.Beta != c.Beta() {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+}
+
+func TestMaxBeta(t *testing.T) {
+	m := newFakeManager()
+	m.q = 2000
+	c := NewControl(m, 1, 10, time.Second)
+
+	c.Step()
+	if b, _, _ := c.Step(); b <= 4 {
+		t.Fatalf("expected a large beta for a large queue, got %v", b)
+	}
+
+	m.maxBeta = 4
+	if b, _, _ := c.Step(); b != 4 {
+		t.Errorf("expected beta capped to 4, got %v", b)
+	}
+	if s := c.Snapshot(); s.Beta != 4 {
+		t.Errorf("expected snapshot beta capped to 4, got %v", s.Beta)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	// Of the 10 messages per second the workers take, 4 fail and come back
+	// on top of the 10 arriving.
+	m := newFakeManager()
+	m.dy, m.dr = 6, 4
+	c := NewControl(m, 1, 10, time.Second)
+
+	c.Step()
+	c.Step()
+	s := c.Snapshot()
+	if s.R != 2 || s.B != 7 {
+		t.Errorf("expected R 2 and b 7 counting retries as work, got %v and %v", s.R, s.B)
+	}
+	if s.DR != 4 || s.FailureRate != 0.4 {
+		t.Errorf("expected a failure rate of 0.4, got %v", s.FailureRate)
+	}
+}
@@ -0,0 +1,77 @@
+package control
+
+import (
+	"math"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/queueing"
+)
+
+// Costs to trade latency against spend, see SetCostModel.
+type costModel struct {
+	workerHour float64 // Cost of a worker for an hour
+	penalty    float64 // Cost of a message waiting longer than mq
+	budget     float64 // Most to spend on workers per hour, zero for none
+}
+
+// Choose b by minimising the expected cost per hour, rather than by matching
+// the arrival rate: workerHour is the cost of running a worker for an hour,
+// and penalty that of every message that's queued for longer than the max
+// queue time. Messages are expected to arrive at dx and to be processed at R
+// per worker, as an M/M/c queue. If budget isn't zero, beta is capped at the
+// workers it pays for per hour, whatever the penalty.
+//
+// The projected cost per hour of the beta set is reported in decisions and
+// snapshots. Queued messages are still drained through k.
+func (c *Control) SetCostModel(workerHour, penalty, budget float64) {
+	if workerHour <= 0 {
+		panic("workerHour must be positive")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.costs = &costModel{workerHour: workerHour, penalty: penalty, budget: budget}
+}
+
+// Workers the budget pays for, or zero for no cap.
+func (m *costModel) maxBeta() float64 {
+	if m.budget <= 0 {
+		return 0
+	}
+	return math.Floor(m.budget / m.workerHour)
+}
+
+// Expected cost per hour of beta workers, for arrivals at dx per unit
+// processed at r per worker per unit, with messages expected to wait at most
+// mq units.
+func (m *costModel) cost(beta int, dx, r float64, mq uint, unit time.Duration) float64 {
+	perHour := float64(time.Hour) / float64(unit)
+	violations := dx * perHour * queueing.WaitExceeds(beta, dx, r, float64(mq))
+	return float64(beta)*m.workerHour + violations*m.penalty
+}
+
+// Workers minimising the expected cost per hour, within the budget.
+func (m *costModel) optimal(dx, r float64, mq uint, unit time.Duration) float64 {
+	if dx <= 0 || r <= 0 {
+		return 0
+	}
+
+	max := m.maxBeta()
+	// Fewer workers than the load never keep up.
+	beta := int(math.Floor(dx/r)) + 1
+	if max > 0 && float64(beta) > max {
+		return max
+	}
+
+	// The cost is convex in beta: the workers grow linearly while the
+	// penalty shrinks ever slower, so the first minimum is the one.
+	best := m.cost(beta, dx, r, mq, unit)
+	for max == 0 || float64(beta+1) <= max {
+		next := m.cost(beta+1, dx, r, mq, unit)
+		if next >= best {
+			break
+		}
+		beta, best = beta+1, next
+	}
+	return float64(beta)
+}
@@ -0,0 +1,46 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCostModel(t *testing.T) {
+	// 10 messages per second at 2 per worker, an offered load of 5 workers,
+	// with 10 more workers to drain the queue in a second.
+	for _, tc := range []struct {
+		name                        string
+		workerHour, penalty, budget float64
+		b, beta                     float64
+	}{
+		{"free latency", 1, 0, 0, 6, 16},
+		{"cheap latency", 1, 0.01, 0, 8, 18},
+		// At 36000 messages per hour, any chance of waiting is expensive.
+		{"expensive latency", 1, 1, 0, 9, 19},
+		{"budget", 1, 1, 4, 4, 4},
+	} {
+		c := NewControl(newFakeManager(), 1, 1, time.Second)
+		c.SetCostModel(tc.workerHour, tc.penalty, tc.budget)
+
+		c.Step()
+		c.Step()
+		s := c.Snapshot()
+		if s.B != tc.b || s.Beta != tc.beta {
+			t.Errorf("%s: expected b %v and beta %v, got %v and %v", tc.name, tc.b, tc.beta, s.B, s.Beta)
+		}
+		if s.Cost < tc.beta*tc.workerHour {
+			t.Errorf("%s: expected a cost of at least %v, got %v", tc.name, tc.beta*tc.workerHour, s.Cost)
+		}
+	}
+}
+
+func TestOptimalWithinBudget(t *testing.T) {
+	m := &costModel{workerHour: 1, penalty: 100, budget: 3}
+	// More load than the budget can pay for.
+	if b := m.optimal(10, 2, 10, time.Second); b != 3 {
+		t.Errorf("expected the workers the budget pays for, got %v", b)
+	}
+	if b := m.optimal(0, 2, 10, time.Second); b != 0 {
+		t.Errorf("expected no workers without arrivals, got %v", b)
+	}
+}
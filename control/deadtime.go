@@ -0,0 +1,151 @@
+package control
+
+import (
+	"math"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/ema"
+)
+
+// Implemented by actuators that know of the workers requested besides those
+// running, e.g. ECS tasks still being provisioned. Managers report them in
+// their observations, see QueryWorkers.
+type PendingActuator interface {
+	Actuator
+
+	// Workers running, requested, and requested but not running yet.
+	Workers() (running, desired, pending uint, err error)
+}
+
+// Query the workers of a, including those pending if it's a PendingActuator,
+// with a single request. Zero desired and pending workers otherwise.
+func QueryWorkers(a Actuator) (running, desired, pending uint, err error) {
+	if p, ok := a.(PendingActuator); ok {
+		return p.Workers()
+	}
+	running, err = a.Beta()
+	return running, 0, 0, err
+}
+
+// Compensation of the time it takes workers to start after being requested,
+// see SetProvisioningDelay.
+type deadTime struct {
+	// Delay configured, in units, or learnt from requests if zero
+	delay  float64
+	learnt *ema.EMA
+
+	// Increases of beta requested and not running yet, by increasing target
+	requests []request
+
+	// Workers pending on the iterations within the delay
+	samples []pendingSample
+}
+
+type request struct {
+	target uint
+	at     time.Time
+}
+
+type pendingSample struct {
+	pending uint
+	at      time.Time
+}
+
+func newDeadTime() deadTime {
+	return deadTime{learnt: ema.NewEMA(10)}
+}
+
+// Compensate the time it takes workers to start after being requested, delay
+// units, or learn it from the time the workers running take to reach beta if
+// zero (the default). It's learnt from every increase of beta once it's
+// reached, and known after the first one.
+//
+// Without compensation, the queue grows while workers are provisioned, and the
+// controller keeps requesting workers for it on top of those already on their
+// way, which start too late and overshoot. With it, the controller works on
+// the queue the plant would have with the pending workers running, as a Smith
+// predictor does: the messages they'd have processed within the delay are
+// taken off Q. That's what Control's k drains, what MPC drains within the max
+// queue time, and what PID estimates the time queued up from, not integrating
+// the error further while workers are pending.
+//
+// Pending workers are taken from Observation.Pending if the plant reports the
+// workers requested, or else from the increases of beta requested within the
+// delay that aren't running yet.
+func (l *loop) SetProvisioningDelay(delay uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deadTime.delay = float64(delay)
+}
+
+// Provisioning delay, in units, configured or learnt. Zero while unknown.
+func (dt *deadTime) provisioningDelay() float64 {
+	if dt.delay > 0 {
+		return dt.delay
+	}
+	return dt.learnt.Value()
+}
+
+// Update from an observation at now, learning from the requests reached, and
+// return the workers pending.
+func (dt *deadTime) observe(obs Observation, now time.Time, unit time.Duration) uint {
+	units := func(d time.Duration) float64 {
+		return float64(d) / float64(unit)
+	}
+
+	for len(dt.requests) > 0 && dt.requests[0].target <= obs.Beta {
+		dt.learnt.Add(units(now.Sub(dt.requests[0].at)))
+		dt.requests = dt.requests[1:]
+	}
+
+	delay := dt.provisioningDelay()
+	if delay > 0 {
+		// Requests not reached in a few delays won't be, e.g. capped by
+		// the actuator's limits.
+		for len(dt.requests) > 0 && units(now.Sub(dt.requests[0].at)) > 3*delay {
+			dt.requests = dt.requests[1:]
+		}
+	}
+
+	pending := obs.Pending
+	if obs.Desired == 0 && len(dt.requests) > 0 {
+		pending = dt.requests[len(dt.requests)-1].target - obs.Beta
+	}
+
+	dt.samples = append(dt.samples, pendingSample{pending, now})
+	for len(dt.samples) > 0 && units(now.Sub(dt.samples[0].at)) >= delay {
+		dt.samples = dt.samples[1:]
+	}
+
+	return pending
+}
+
+// Messages the plant would have queued up if the workers pending had been
+// running, each processing r per unit, for the last control periods of t
+// units within the delay.
+func (dt *deadTime) backlog(Q uint, r float64, t uint) float64 {
+	var pending float64
+	for _, s := range dt.samples {
+		pending += float64(s.pending)
+	}
+	return math.Max(float64(Q)-r*pending*float64(t), 0)
+}
+
+// Record beta being requested at now, with running workers.
+func (dt *deadTime) request(beta float64, running uint, now time.Time) {
+	target := uint(math.Round(beta))
+
+	// Requests above beta are cancelled, but the workers for beta were
+	// requested with them.
+	at := now
+	n := len(dt.requests)
+	for n > 0 && dt.requests[n-1].target > target {
+		at = dt.requests[n-1].at
+		n--
+	}
+	dt.requests = dt.requests[:n]
+
+	if target > running && (n == 0 || dt.requests[n-1].target < target) {
+		dt.requests = append(dt.requests, request{target, at})
+	}
+}
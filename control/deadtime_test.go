@@ -0,0 +1,120 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadTimeLearnsDelay(t *testing.T) {
+	dt := newDeadTime()
+	start := time.Unix(0, 0)
+	at := func(s int) time.Time {
+		return start.Add(time.Duration(s) * time.Second)
+	}
+
+	// 5 workers running, 8 requested; they start after 2 minutes.
+	dt.request(8, 5, at(0))
+	if p := dt.observe(Observation{Beta: 5}, at(10), time.Second); p != 3 {
+		t.Errorf("expected 3 workers pending inferred from the request, got %d", p)
+	}
+	if p := dt.observe(Observation{Beta: 5, Desired: 8, Pending: 2}, at(20), time.Second); p != 2 {
+		t.Errorf("expected the workers pending reported, got %d", p)
+	}
+	if d := dt.provisioningDelay(); d != 0 {
+		t.Errorf("expected an unknown delay, got %v", d)
+	}
+	dt.observe(Observation{Beta: 8, Desired: 8}, at(120), time.Second)
+	if d := dt.provisioningDelay(); d != 120 {
+		t.Errorf("expected a delay of 120 learnt, got %v", d)
+	}
+
+	// Requests cancelled by a lower beta aren't learnt from.
+	dt.request(12, 8, at(200))
+	dt.request(7, 8, at(210))
+	dt.observe(Observation{Beta: 12}, at(400), time.Second)
+	if d := dt.provisioningDelay(); d != 120 {
+		t.Errorf("expected the delay to stay at 120, got %v", d)
+	}
+
+	dt.delay = 60
+	if d := dt.provisioningDelay(); d != 60 {
+		t.Errorf("expected the delay configured, got %v", d)
+	}
+}
+
+func TestDeadTimeBacklog(t *testing.T) {
+	dt := newDeadTime()
+	dt.delay = 30
+	start := time.Unix(0, 0)
+
+	// 2 workers pending for the last 3 periods of 10 seconds, which would
+	// have processed 2 messages per second each.
+	for i := 0; i < 5; i++ {
+		dt.observe(Observation{Beta: 5, Desired: 7, Pending: 2}, start.Add(time.Duration(i)*10*time.Second), time.Second)
+	}
+	if q := dt.backlog(200, 2, 10); q != 200-2*2*3*10 {
+		t.Errorf("expected a backlog of 80, got %v", q)
+	}
+	if q := dt.backlog(50, 2, 10); q != 0 {
+		t.Errorf("expected no backlog, got %v", q)
+	}
+}
+
+func TestProvisioningDelay(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 60, time.Second)
+	c.SetProvisioningDelay(30)
+
+	c.Step()
+	c.Step()
+	k := c.K()
+
+	// Workers pending are expected to take care of part of the queue.
+	m.pending = 1
+	for i := 0; i < 30; i++ {
+		c.Step()
+	}
+	if s := c.Snapshot(); s.Pending != 1 || s.ProvisioningDelay != 30 || s.K >= k {
+		t.Errorf("expected k under %v with a worker pending, got %+v", k, s)
+	}
+}
+
+// PID and MPC compensate the delay as Control does, setting fewer workers for
+// the same queue while some are pending.
+func TestProvisioningDelayControllers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		new  func(m Manager) Controller
+	}{
+		{"pid", func(m Manager) Controller {
+			p := NewPID(m, 1, 1, time.Second, 0.5, 0.05, 0)
+			p.SetProvisioningDelay(30)
+			return p
+		}},
+		{"mpc", func(m Manager) Controller {
+			p := NewMPC(m, 1, 10, time.Second)
+			p.SetProvisioningDelay(30)
+			return p
+		}},
+	} {
+		var betas [2]float64
+		for pending := range betas {
+			m := newFakeManager()
+			m.pending = uint(pending)
+			c := tc.new(m)
+			for i := 0; i < 30; i++ {
+				c.Step()
+			}
+
+			s := c.Snapshot()
+			if s.Pending != uint(pending) || s.ProvisioningDelay != 30 {
+				t.Errorf("%s: expected %d workers pending in %+v", tc.name, pending, s)
+			}
+			betas[pending] = s.Beta
+		}
+
+		if betas[1] >= betas[0] {
+			t.Errorf("%s: expected beta under %v with a worker pending, got %v", tc.name, betas[0], betas[1])
+		}
+	}
+}
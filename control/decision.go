@@ -0,0 +1,146 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Branch of the control loop taken on an iteration.
+type Branch int
+
+const (
+	ColdStart  Branch = iota // No data to estimate R from yet
+	Queued                   // Q > B, workers at full speed
+	Overscaled               // W > 0, overscaled or in equilibrium
+	Idle                     // X = Y
+	PIDControl               // Beta set by a PID controller
+	Predictive               // Beta set by a model predictive controller
+	Failed                   // The plant couldn't be sampled
+)
+
+var branchNames = []string{"cold_start", "queued", "overscaled", "idle", "pid", "mpc", "failed"}
+
+func (b Branch) String() string {
+	if b < 0 || int(b) >= len(branchNames) {
+		return fmt.Sprintf("Branch(%d)", int(b))
+	}
+	return branchNames[b]
+}
+
+func (b Branch) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b *Branch) UnmarshalText(text []byte) error {
+	for i, name := range branchNames {
+		if name == string(text) {
+			*b = Branch(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown branch %s", text)
+}
+
+// Record of the decision taken on an iteration of the control loop: its inputs,
+// the branch taken, the estimates computed and the resulting beta.
+type Decision struct {
+	Iteration uint64    `json:"iteration"`
+	Time      time.Time `json:"time"`
+
+	// Inputs
+	DX float64 `json:"dx"`
+	DY float64 `json:"dy"`
+	Q  uint    `json:"q"`
+	W  uint    `json:"w"`
+	B  uint    `json:"b"`
+
+	// Cap on beta, if the plant has got one
+	MaxBeta uint `json:"max_beta,omitempty"`
+
+	// Workers requested and not running yet
+	Pending uint `json:"pending,omitempty"`
+
+	// Failed attempts, dead letters and time in queue, if the plant reports
+	// them
+	DR          float64   `json:"dr,omitempty"`
+	DeadLetters uint      `json:"dead_letters,omitempty"`
+	Age         *QueueAge `json:"age,omitempty"`
+
+	Branch Branch `json:"branch"`
+
+	// Estimates; Bh is the b estimate, as opposed to the measured B
+	R  float64 `json:"r"`
+	Bh float64 `json:"b_estimate"`
+	K  float64 `json:"k"`
+
+	// Terms of the output of a PID controller, if that's what decided
+	PID *PIDTerms `json:"pid,omitempty"`
+
+	// Waits predicted for the candidate betas, if a model predictive
+	// controller decided
+	Predictions []Prediction `json:"predictions,omitempty"`
+
+	// Beta computed, and whether it was set on the plant. On failures, the
+	// failsafe beta when it's set, and the beta held otherwise
+	Beta float64 `json:"beta"`
+	Set  bool    `json:"set"`
+
+	// Projected cost per hour of beta, if there's a cost model
+	Cost float64 `json:"cost,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Receiver of decision records. Records are delivered synchronously from the
+// control loop, so sinks shouldn't block.
+type Sink interface {
+	Record(d Decision)
+}
+
+// Adapter to use a function as a Sink.
+type SinkFunc func(d Decision)
+
+func (f SinkFunc) Record(d Decision) {
+	f(d)
+}
+
+// Sink delivering records to a channel. Records are dropped rather than
+// blocking the control loop if the channel isn't ready.
+type ChannelSink chan<- Decision
+
+func (ch ChannelSink) Record(d Decision) {
+	select {
+	case ch <- d:
+	default:
+	}
+}
+
+// Sink writing records as JSON lines.
+type JSONLSink struct {
+	encoder *json.Encoder
+	err     error
+	sync.Mutex
+}
+
+func NewJSONLSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *JSONLSink) Record(d Decision) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.encoder.Encode(d); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// First error writing records, if any.
+func (s *JSONLSink) Err() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
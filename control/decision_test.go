@@ -0,0 +1,143 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecisionRecords(t *testing.T) {
+	m := newFakeManager()
+	m.age = &QueueAge{Oldest: 12, Latency: 3, Percentile: 0.95}
+	c := NewControl(m, 1, 10, time.Second)
+
+	var buf bytes.Buffer
+	jsonl := NewJSONLSink(&buf)
+	records := []Decision{}
+	c.AddSink(SinkFunc(func(d Decision) {
+		records = append(records, d)
+	}))
+	c.AddSink(jsonl)
+
+	c.Step()
+	c.Step()
+	m.errs <- errors.New("throttled")
+	c.Step()
+
+	expected := []struct {
+		branch Branch
+		set    bool
+	}{
+		{Queued, false}, // Not set on the first iteration
+		{Queued, true},
+		{Failed, false},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, e := range expected {
+		d := records[i]
+		if d.Iteration != uint64(i+1) || d.Branch != e.branch || d.Set != e.set {
+			t.Errorf("record %d: expected branch %s, set %v; got iteration %d, branch %s, set %v",
+				i, e.branch, e.set, d.Iteration, d.Branch, d.Set)
+		}
+	}
+	if d := records[1]; d.Q != m.q || d.W != m.xmy-m.q || d.B != m.beta || d.Beta != c.Beta() {
+		t.Errorf("unexpected inputs or beta in %+v", d)
+	}
+	if records[2].Error != "throttled" {
+		t.Errorf("expected the sampling error to be recorded, got %q", records[2].Error)
+	}
+	// No failsafe was set, so the last beta is held.
+	if records[2].Beta != records[1].Beta {
+		t.Errorf("expected beta %v held on failure, got %v", records[1].Beta, records[2].Beta)
+	}
+
+	if err := jsonl.Err(); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	for i := 0; scanner.Scan(); i++ {
+		var d Decision
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatalf("line %d: %s", i, err)
+		}
+		if d.Branch != records[i].Branch || d.Beta != records[i].Beta {
+			t.Errorf("line %d: expected %+v, got %+v", i, records[i], d)
+		}
+		if i == 0 && !bytes.Contains(scanner.Bytes(), []byte(`"age":{"oldest":12,"latency":3,"percentile":0.95}`)) {
+			t.Errorf("line %d: unexpected age encoding in %s", i, scanner.Bytes())
+		}
+	}
+}
+
+func TestJSONLRecordsWhileIdle(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 10, time.Second)
+
+	var buf bytes.Buffer
+	jsonl := NewJSONLSink(&buf)
+	c.AddSink(jsonl)
+
+	c.Step()
+	c.Step()
+	before := c.Snapshot()
+
+	// Nothing arrives while the last messages are processed.
+	m.dx, m.dy, m.q, m.xmy = 0, 2, 0, 3
+	c.Step()
+
+	if err := jsonl.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var last Decision
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	for ; scanner.Scan(); lines++ {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("line %d: %s", lines, err)
+		}
+	}
+	if lines != 3 {
+		t.Fatalf("expected 3 records, got %d", lines)
+	}
+	if last.Branch != Overscaled || last.R != before.R || last.Bh != before.B {
+		t.Errorf("expected R and b kept while idle, got %+v", last)
+	}
+}
+
+func TestFailedDecisionBeta(t *testing.T) {
+	m := newFakeManager()
+	c := NewControl(m, 1, 10, time.Second)
+	c.SetFailsafe(2, 7)
+
+	records := []Decision{}
+	c.AddSink(SinkFunc(func(d Decision) {
+		records = append(records, d)
+	}))
+
+	// Nothing is set on the first iteration, so the plant's beta is held
+	// until the failsafe is.
+	c.Step()
+	for i := 0; i < 3; i++ {
+		m.errs <- errors.New("throttled")
+		c.Step()
+	}
+
+	expected := []struct {
+		beta float64
+		set  bool
+	}{
+		{5, false},
+		{7, true}, // Failsafe
+		{7, false},
+	}
+	for i, e := range expected {
+		if d := records[i+1]; d.Beta != e.beta || d.Set != e.set {
+			t.Errorf("record %d: expected beta %v, set %v; got %v, %v", i, e.beta, e.set, d.Beta, d.Set)
+		}
+	}
+}
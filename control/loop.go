@@ -0,0 +1,292 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+)
+
+// Returned by Run when the shutdown beta couldn't be sent to the plant within
+// the shutdown timeout, e.g. because it stopped reading on the same context.
+var ErrShutdownBeta = errors.New("shutdown beta not delivered")
+
+// A control law closing the loop on a plant, such as Control or PID. Either
+// can be run on the same plants, or stepped by a simulation, to compare them.
+type Controller interface {
+	// Run the control loop until ctx is cancelled.
+	Run(ctx context.Context) error
+
+	// Run a single iteration, sampling the plant and computing the beta to
+	// be set, if any.
+	Step() (beta float64, set bool, err error)
+
+	// Consistent copy of the queryable state, as of the end of an
+	// iteration.
+	Snapshot() Snapshot
+}
+
+// The loop running a control law: sampling the plant every control period,
+// handling failures, recording decisions and setting beta. Shared by every
+// Controller, which embed it.
+type loop struct {
+	plant Manager
+	clock clock.Clock
+
+	dryRun bool
+
+	// Beta to send to the plant when Run is stopped, if shutdown is set,
+	// and how long to wait for the plant to take it.
+	shutdown        bool
+	shutdownBeta    float64
+	shutdownTimeout time.Duration
+
+	// Failure handling. After maxFailures consecutive failed samples (if
+	// non-zero), failsafeBeta is set.
+	failures           uint
+	maxFailures        uint
+	failsafeBeta       float64
+	retryMin, retryMax time.Duration
+
+	// Beta held while sampling fails: the last one set, or the plant's
+	// until one is
+	held    float64
+	heldSet bool
+
+	// Settings
+	t    uint
+	unit time.Duration
+
+	// Iterations run, the time and branch of the last one, and where their
+	// decisions are recorded
+	iteration uint64
+	time      time.Time
+	branch    Branch
+	sinks     []Sink
+
+	// Workers pending, and the compensation of their provisioning delay
+	pending  uint
+	deadTime deadTime
+
+	// Guards the state, which Step writes while getters read it
+	mu sync.RWMutex
+}
+
+func newLoop(plant Manager, controlPeriod uint, unit time.Duration) loop {
+	return loop{
+		plant:    plant,
+		clock:    clock.Real{},
+		t:        controlPeriod,
+		unit:     unit,
+		retryMin: unit,
+		retryMax: time.Duration(controlPeriod) * unit,
+
+		shutdownTimeout: time.Duration(controlPeriod) * unit,
+
+		deadTime: newDeadTime(),
+	}
+}
+
+// Use clk instead of the wall clock. Must be called before Run.
+func (l *loop) SetClock(clk clock.Clock) {
+	l.clock = clk
+}
+
+func (l *loop) SetDryRun() {
+	l.dryRun = true
+}
+
+// Set a beta value to be sent to the plant once Run is stopped, e.g. to leave
+// the plant at a known size while the controller is redeployed. By default
+// nothing is sent and the plant is left as it was last set.
+func (l *loop) SetShutdownBeta(beta float64) {
+	l.shutdown = true
+	l.shutdownBeta = beta
+}
+
+// Set how long Run waits for the plant to take the shutdown beta before giving
+// up and returning ErrShutdownBeta. One control period by default.
+func (l *loop) SetShutdownTimeout(timeout time.Duration) {
+	l.shutdownTimeout = timeout
+}
+
+// Set the beta the plant falls back to after threshold consecutive failures to
+// sample it. It's set once, when the threshold is reached; until then, and
+// after it, the plant is left at the last beta set.
+func (l *loop) SetFailsafe(threshold uint, beta float64) {
+	l.maxFailures = threshold
+	l.failsafeBeta = beta
+}
+
+// Set the delays between retries after failing to sample the plant. The delay
+// starts at min and doubles on every consecutive failure up to max. By default
+// it goes from one unit to the control period.
+func (l *loop) SetRetryBackoff(min, max time.Duration) {
+	if min > max {
+		panic("min > max")
+	}
+	l.retryMin = min
+	l.retryMax = max
+}
+
+// Record the decision taken on every iteration to s.
+func (l *loop) AddSink(s Sink) {
+	l.sinks = append(l.sinks, s)
+}
+
+// Number of consecutive failures to sample the plant.
+func (l *loop) Failures() uint {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.failures
+}
+
+// Workers requested that weren't running on the last iteration.
+func (l *loop) Pending() uint {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.pending
+}
+
+// Provisioning delay, in units, as configured or learnt. Zero while unknown.
+func (l *loop) ProvisioningDelay() float64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.deadTime.provisioningDelay()
+}
+
+// Run the loop until ctx is cancelled, calling step every control period.
+func (l *loop) run(ctx context.Context, step func() (float64, bool, error)) error {
+	period := time.Duration(l.t) * l.unit
+	delay := period
+
+	for {
+		if !l.sleep(ctx, delay) {
+			return l.stop(ctx)
+		}
+
+		beta, set, err := step()
+		if err != nil {
+			delay = l.backoff()
+			log.Printf("Error sampling plant (%d consecutive): %s", l.Failures(), err)
+		} else {
+			delay = period
+		}
+
+		if !set || l.dryRun {
+			continue
+		}
+
+		// Set b
+		select {
+		case l.plant.SetB() <- beta:
+		case <-ctx.Done():
+			return l.stop(ctx)
+		}
+
+	}
+
+}
+
+// Sample the plant and update the control law with the observation, returning
+// the beta decided and whether to set it. update is called with the lock held,
+// and the workers pending already observed.
+func (l *loop) step(update func(Observation) (Decision, bool)) (beta float64, set bool, err error) {
+	obs, err := l.plant.Sample(l.unit)
+
+	l.mu.Lock()
+	l.iteration++
+	l.time = l.clock.Now()
+
+	var d Decision
+	if err != nil {
+		l.failures++
+
+		// Fall back to the failsafe beta once, otherwise hold the last one
+		// set.
+		set = l.failures == l.maxFailures
+		if set {
+			l.held, l.heldSet = l.failsafeBeta, true
+		}
+		d = Decision{
+			Branch: Failed,
+			Beta:   l.held,
+			Error:  err.Error(),
+		}
+	} else {
+		l.failures = 0
+		l.pending = l.deadTime.observe(obs, l.time, l.unit)
+		d, set = update(obs)
+		if set {
+			l.held, l.heldSet = d.Beta, true
+		} else if !l.heldSet {
+			l.held = float64(obs.Beta)
+		}
+		if set && !l.dryRun {
+			l.deadTime.request(d.Beta, obs.Beta, l.time)
+		}
+	}
+
+	d.Iteration, d.Time = l.iteration, l.time
+	d.Set = set && !l.dryRun
+	l.branch = d.Branch
+	l.mu.Unlock()
+
+	// Sinks are called without holding the lock, so they can query the
+	// controller.
+	for _, s := range l.sinks {
+		s.Record(d)
+	}
+
+	return d.Beta, set, err
+}
+
+func (l *loop) stop(ctx context.Context) error {
+	if !l.shutdown || l.dryRun {
+		return ctx.Err()
+	}
+
+	// The context is already done, so this can't be bound to it; the plant
+	// may have stopped reading on it too, so it's bound to the timeout.
+	t := l.clock.NewTimer(l.shutdownTimeout)
+	defer t.Stop()
+
+	select {
+	case l.plant.SetB() <- l.shutdownBeta:
+		return ctx.Err()
+	case <-t.C():
+		return fmt.Errorf("%w after %v (stopped on %v)", ErrShutdownBeta, l.shutdownTimeout, ctx.Err())
+	}
+}
+
+// Delay before retrying after the current number of consecutive failures.
+func (l *loop) backoff() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	d := l.retryMin
+	for i := uint(1); i < l.failures && d < l.retryMax; i++ {
+		d *= 2
+	}
+	if d > l.retryMax {
+		d = l.retryMax
+	}
+	return d
+}
+
+// Sleep for d, returning false if ctx was cancelled in the meantime.
+func (l *loop) sleep(ctx context.Context, d time.Duration) bool {
+	t := l.clock.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,305 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/ema"
+	"github.com/Lowercases/queue-scaling/queueing"
+)
+
+// Candidates evaluated past the load before giving up on meeting the max queue
+// time, if the plant doesn't cap beta.
+const maxCandidates = 1000
+
+// A model predictive controller, as an alternative to Control: rather than
+// following the branches of Control, it fits a queueing model to the plant
+// and sets the fewest workers predicted to keep messages queued up for at most
+// the max queue time, at a percentile.
+//
+// The plant is modelled as an M/G/c queue: messages arrive at dx, plus the
+// failed attempts coming back to be retried, and every worker processes as many
+// messages at once as its internal concurrency, each at R divided by it. The
+// wait is predicted through Erlang C, as for an M/M/c queue, and scaled by
+// the variability of the processing times as in the Allen-Cunneen
+// approximation. Messages already queued up are drained in the max queue
+// time, as extra arrivals, but for those the workers pending would have taken,
+// see SetProvisioningDelay.
+type MPC struct {
+	loop
+
+	// Settings
+	mq          uint
+	percentile  float64
+	variability float64 // Squared coefficient of variation of processing times
+
+	// Estimation: R, as historic y / beta and as measured while the
+	// workers are busy, and internal concurrency
+	y, betaIntegral     *ema.EMA
+	busyR               *ema.EMA
+	internalConcurrency *ema.EMA
+
+	// Queryable state
+	obs         Observation
+	lambda, r   float64 // Fitted arrival rate and throughput per worker
+	beta        float64
+	predictions []Prediction
+
+	// Whether the first iteration has been run
+	started bool
+}
+
+// Predicted wait of messages for a candidate beta.
+type Prediction struct {
+	Beta uint `json:"beta"`
+
+	// Wait at the percentile, in units; infinite if the workers can't keep
+	// up
+	Wait float64 `json:"wait"`
+
+	// Probability of a message waiting longer than the max queue time
+	Exceeds float64 `json:"exceeds"`
+}
+
+// Infinite waits are marshalled as -1, since JSON has got no infinity.
+func (p Prediction) MarshalJSON() ([]byte, error) {
+	type prediction Prediction
+	if math.IsInf(p.Wait, 1) {
+		p.Wait = -1
+	}
+	return json.Marshal(prediction(p))
+}
+
+// MPC keeping 95% of messages queued up for at most maxQueueTime units.
+func NewMPC(plant Manager, controlPeriod, maxQueueTime uint, unit time.Duration) *MPC {
+	return &MPC{
+		loop:                newLoop(plant, controlPeriod, unit),
+		mq:                  maxQueueTime,
+		percentile:          0.95,
+		variability:         1,
+		y:                   ema.NewEMI(100),
+		betaIntegral:        ema.NewEMI(100),
+		busyR:               ema.NewEMA(20),
+		internalConcurrency: ema.NewEMA(20),
+	}
+}
+
+// Percentile of the messages to keep within the max queue time, 0.95 by
+// default.
+func (m *MPC) SetPercentile(p float64) {
+	if p <= 0 || p >= 1 {
+		panic("percentile must be in (0, 1)")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.percentile = p
+}
+
+// Squared coefficient of variation of the processing times: their variance
+// over the square of their mean. 1 by default, as for exponential times (an
+// M/M/c queue); 0 for constant ones.
+func (m *MPC) SetVariability(cs2 float64) {
+	if cs2 < 0 {
+		panic("variability must be positive")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.variability = cs2
+}
+
+// Run the control loop until ctx is cancelled. The reason it stopped is
+// returned, after sending the shutdown beta (if any) to the plant, or
+// ErrShutdownBeta if the plant doesn't take it within the shutdown timeout.
+func (m *MPC) Run(ctx context.Context) error {
+	return m.run(ctx, m.Step)
+}
+
+// Run a single iteration of the control loop, as Control.Step does.
+func (m *MPC) Step() (beta float64, set bool, err error) {
+	return m.step(m.update)
+}
+
+// Update the state from a new observation, returning the decision taken and
+// whether beta should be set. Must be called with the lock held.
+func (m *MPC) update(obs Observation) (Decision, bool) {
+	m.obs = obs
+	B, Q := obs.Beta, obs.Q
+	W := obs.XmY - Q
+
+	// Failed attempts are work for the workers, as in Control.
+	attempts := obs.DY + obs.DR
+	m.lambda = obs.DX + obs.DR
+	m.y.Add(attempts)
+	m.betaIntegral.Add(float64(B))
+
+	if Q > B && B > 0 {
+		// Workers at full speed
+		m.busyR.Add(attempts / float64(B))
+		m.internalConcurrency.Add(float64(W) / float64(B))
+	}
+
+	var branch Branch
+	m.predictions = nil
+	if m.y.Value()*float64(m.t) < 1 || m.betaIntegral.Value() < 1 {
+		branch = ColdStart
+		// No reference yet, as in Control.
+		m.r = 0
+		if Q+W == 0 {
+			m.beta = 0
+		} else if B > 0 {
+			m.beta = float64(B)
+		} else {
+			m.beta = 1
+		}
+	} else {
+		branch = Predictive
+		// Historic R is a lower bound, since workers might have starved.
+		m.r = math.Max(m.y.Value()/m.betaIntegral.Value(), m.busyR.Value())
+		m.beta = m.optimal(Q, W, obs.MaxBeta)
+	}
+
+	d := Decision{
+		DX:          obs.DX,
+		DY:          obs.DY,
+		Q:           Q,
+		W:           W,
+		B:           B,
+		MaxBeta:     obs.MaxBeta,
+		Pending:     m.pending,
+		DR:          obs.DR,
+		DeadLetters: obs.DeadLetters,
+		Age:         obs.Age,
+		Branch:      branch,
+		R:           m.r,
+		Beta:        m.beta,
+		Predictions: m.predictions,
+	}
+	if m.r > 0 {
+		d.Bh = m.lambda / m.r
+	}
+
+	// Don't set beta the first iteration, as with Control.
+	set := m.started
+	m.started = true
+	return d, set
+}
+
+// Fewest workers predicted to meet the max queue time, recording the
+// predictions for every candidate evaluated. Never fewer than those busy.
+func (m *MPC) optimal(Q, W, maxBeta uint) float64 {
+	concurrency := m.concurrency()
+	min := uint(math.Ceil(float64(W) / concurrency))
+
+	// Drain the queue there'd be with the workers pending running, which
+	// those requested for it are.
+	lambda := m.lambda + m.deadTime.backlog(Q, m.r, m.t)/float64(m.mq)
+	if lambda <= 0 {
+		return float64(min)
+	}
+
+	// Fewer workers than the load never keep up.
+	beta := uint(math.Floor(lambda/m.r)) + 1
+	if beta < min {
+		beta = min
+	}
+	last := beta + maxCandidates
+	if maxBeta > 0 {
+		if beta > maxBeta {
+			return float64(maxBeta)
+		}
+		last = maxBeta
+	}
+
+	for ; ; beta++ {
+		p := m.predict(beta, lambda, concurrency)
+		m.predictions = append(m.predictions, p)
+		if p.Wait <= float64(m.mq) || beta == last {
+			return float64(beta)
+		}
+	}
+}
+
+// Predicted wait for beta workers, with messages arriving at lambda.
+func (m *MPC) predict(beta uint, lambda, concurrency float64) Prediction {
+	// Every worker is as many servers as messages it processes at once.
+	c := int(math.Max(math.Round(float64(beta)*concurrency), 1))
+	mu := m.r * float64(beta) / float64(c)
+
+	// Allen-Cunneen: waits grow with the variability of the processing
+	// times, Poisson arrivals having a variability of 1.
+	scale := (1 + m.variability) / 2
+	mq := float64(m.mq)
+	return Prediction{
+		Beta:    beta,
+		Wait:    queueing.WaitPercentile(c, lambda, mu, m.percentile) * scale,
+		Exceeds: queueing.WaitExceeds(c, lambda, mu, mq/scale),
+	}
+}
+
+// Messages processed at once per worker, if it's been confirmed to be over 1.
+func (m *MPC) concurrency() float64 {
+	return math.Max(m.internalConcurrency.Value(), 1)
+}
+
+// The getters below are safe to call while the controller runs; use Snapshot
+// for a consistent view.
+
+func (m *MPC) Beta() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.beta
+}
+
+// Fitted throughput per worker, per unit.
+func (m *MPC) R() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.r
+}
+
+// Predicted waits for the candidate betas evaluated on the last iteration, up
+// to the one chosen.
+func (m *MPC) Predictions() []Prediction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Prediction(nil), m.predictions...)
+}
+
+// Snapshot of the state, with the estimates an MPC doesn't make left at zero.
+func (m *MPC) Snapshot() Snapshot {
+	mu_p, ok := m.plant.MuP()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mu_p = mup(m.obs, mu_p, ok)
+	var b, failureRate float64
+	if m.r > 0 {
+		b = m.lambda / m.r
+	}
+	if attempts := m.obs.DY + m.obs.DR; attempts > 0 {
+		failureRate = m.obs.DR / attempts
+	}
+	return Snapshot{
+		Iteration:           m.iteration,
+		Time:                m.time,
+		Branch:              m.branch,
+		Observation:         m.obs,
+		DX:                  m.obs.DX,
+		DY:                  m.obs.DY,
+		DR:                  m.obs.DR,
+		R:                   m.r,
+		B:                   b,
+		Beta:                m.beta,
+		Pending:             m.pending,
+		ProvisioningDelay:   m.deadTime.provisioningDelay(),
+		MuP:                 mu_p,
+		InternalConcurrency: m.internalConcurrency.Value(),
+		FailureRate:         failureRate,
+		Failures:            m.failures,
+	}
+}
@@ -0,0 +1,95 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMPC(t *testing.T) {
+	m := newFakeManager()
+	c := NewMPC(m, 1, 1, time.Second)
+
+	c.Step()
+	beta, set, _ := c.Step()
+	if !set || c.R() != 2 {
+		t.Fatalf("expected beta to be set with an R of 2, got %v and R %v", set, c.R())
+	}
+
+	// 30 messages per second: 10 arriving and 20 to drain the queue in a
+	// second, on two servers per worker at one message per second each.
+	predictions := c.Predictions()
+	if len(predictions) == 0 || float64(predictions[len(predictions)-1].Beta) != beta {
+		t.Fatalf("expected predictions up to beta %v, got %+v", beta, predictions)
+	}
+	if predictions[0].Beta != 16 || beta != 17 {
+		t.Errorf("expected candidates from the first to keep up with the load to 17, got %+v", predictions)
+	}
+	for i, p := range predictions {
+		if i < len(predictions)-1 && p.Wait <= 1 {
+			t.Errorf("expected beta %d to miss the max queue time, got %+v", p.Beta, p)
+		}
+		if i > 0 && (p.Wait >= predictions[i-1].Wait || p.Exceeds >= predictions[i-1].Exceeds) {
+			t.Errorf("expected waits to shrink with beta, got %+v", predictions)
+		}
+	}
+	if last := predictions[len(predictions)-1]; last.Wait > 1 || last.Exceeds > 0.05 {
+		t.Errorf("expected beta %v to meet the max queue time, got %+v", beta, last)
+	}
+}
+
+func TestMPCPercentileAndVariability(t *testing.T) {
+	run := func(p, cs2 float64) float64 {
+		c := NewMPC(newFakeManager(), 1, 1, time.Second)
+		c.SetPercentile(p)
+		c.SetVariability(cs2)
+		c.Step()
+		beta, _, _ := c.Step()
+		return beta
+	}
+
+	base := run(0.95, 1)
+	if b := run(0.5, 1); b >= base {
+		t.Errorf("expected fewer than %v workers for the median, got %v", base, b)
+	}
+	if b := run(0.95, 4); b <= base {
+		t.Errorf("expected more than %v workers for variable processing times, got %v", base, b)
+	}
+	if b := run(0.95, 0); b >= base {
+		t.Errorf("expected fewer than %v workers for constant processing times, got %v", base, b)
+	}
+}
+
+func TestMPCKeepsBusyWorkers(t *testing.T) {
+	m := newFakeManager()
+	m.dx, m.q = 0, 0 // Draining the last messages
+	c := NewMPC(m, 1, 10, time.Second)
+
+	c.Step()
+	if beta, _, _ := c.Step(); beta != float64(m.xmy) {
+		t.Errorf("expected the %d busy workers to be kept, got %v", m.xmy, beta)
+	}
+}
+
+func TestMPCMaxBeta(t *testing.T) {
+	m := newFakeManager()
+	m.maxBeta = 4
+	c := NewMPC(m, 1, 10, time.Second)
+
+	c.Step()
+	if beta, _, _ := c.Step(); beta != 4 {
+		t.Errorf("expected beta capped to 4, got %v", beta)
+	}
+}
+
+func TestPredictionJSON(t *testing.T) {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(Decision{Predictions: []Prediction{{Beta: 1, Wait: math.Inf(1), Exceeds: 1}}})
+
+	var d Decision
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil || d.Predictions[0].Wait != -1 {
+		t.Errorf("expected an infinite wait as -1, got %+v (%v)", d.Predictions, err)
+	}
+}
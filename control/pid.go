@@ -0,0 +1,250 @@
+package control
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// A classical PID controller, as an alternative to Control: beta is set from
+// the error between the time messages spend queued up and the max queue time,
+// with no model of the plant. It's meant to be compared against Control on the
+// same plants, see Controller.
+//
+// The output is in position form, the integral term absorbing the workers the
+// plant needs at equilibrium, and starts from the workers running so that
+// taking over a plant doesn't bump it. To keep the integral from winding up
+// while the output is clamped, it's only integrated when that moves the output
+// back within its limits, nor up while workers requested are still starting,
+// see SetProvisioningDelay. The derivative is taken on the error every control
+// period and smoothed, since queue times are noisy.
+type PID struct {
+	loop
+
+	// Settings
+	mq         uint
+	kp, ki, kd float64
+	min, max   float64 // Output limits, no maximum if zero
+	alpha      float64 // Derivative filter, the weight of the last derivative
+
+	// Queryable state
+	obs        Observation
+	terms      PIDTerms
+	integral   float64 // Integral term, already multiplied by ki
+	derivative float64 // Filtered derivative of the error, per unit
+	beta       float64
+
+	// Whether the first iteration has been run
+	started bool
+}
+
+// Terms of the output of a PID controller, in workers, and the error they
+// were computed from, in units.
+type PIDTerms struct {
+	Error float64 `json:"error"`
+	P     float64 `json:"p"`
+	I     float64 `json:"i"`
+	D     float64 `json:"d"`
+}
+
+// PID controller keeping messages queued up for maxQueueTime units, with gains
+// in workers per unit of error (kp), per unit of error and unit of time (ki),
+// and per unit of error per unit of time (kd).
+func NewPID(plant Manager, controlPeriod, maxQueueTime uint, unit time.Duration, kp, ki, kd float64) *PID {
+	return &PID{
+		loop:  newLoop(plant, controlPeriod, unit),
+		mq:    maxQueueTime,
+		kp:    kp,
+		ki:    ki,
+		kd:    kd,
+		alpha: 0.5,
+	}
+}
+
+// Clamp beta to between min and max workers; a zero max doesn't limit it. By
+// default beta goes from zero workers, and is only capped by the plant.
+func (p *PID) SetOutputLimits(min, max float64) {
+	if min < 0 || (max > 0 && min > max) {
+		panic("invalid output limits")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.min, p.max = min, max
+}
+
+// Weight of the last derivative on the next, from 0 (unfiltered) to 1
+// (excluded); 0.5 by default.
+func (p *PID) SetDerivativeFilter(alpha float64) {
+	if alpha < 0 || alpha >= 1 {
+		panic("alpha must be in [0, 1)")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.alpha = alpha
+}
+
+// Run the control loop until ctx is cancelled. The reason it stopped is
+// returned, after sending the shutdown beta (if any) to the plant, or
+// ErrShutdownBeta if the plant doesn't take it within the shutdown timeout.
+func (p *PID) Run(ctx context.Context) error {
+	return p.run(ctx, p.Step)
+}
+
+// Run a single iteration of the control loop, as Control.Step does.
+func (p *PID) Step() (beta float64, set bool, err error) {
+	return p.step(p.update)
+}
+
+// Update the state from a new observation, returning the decision taken and
+// whether beta should be set. Must be called with the lock held.
+func (p *PID) update(obs Observation) (Decision, bool) {
+	p.obs = obs
+	dt := float64(p.t)
+
+	e := p.queueError(obs)
+	set := p.started
+	if !p.started {
+		// Bumpless start: the integral takes whatever the other terms
+		// leave to the workers running. Beta isn't set the first
+		// iteration, as with Control.
+		p.started = true
+		p.integral = float64(obs.Beta) - p.kp*e - p.ki*e*dt
+		p.terms.Error = e
+	}
+
+	de := (e - p.terms.Error) / dt
+	p.derivative = p.alpha*p.derivative + (1-p.alpha)*de
+
+	integral := p.integral + p.ki*e*dt
+	output := p.kp*e + integral + p.kd*p.derivative
+	p.beta = p.clamp(output, obs.MaxBeta)
+
+	// Conditional integration: keep the integral as it was if it would only
+	// push the output further past its limits, or up before the workers
+	// pending have had a chance to bring the error down.
+	windup := (output > p.beta && e > 0) || (output < p.beta && e < 0)
+	if !windup && !(p.pending > 0 && e > 0) {
+		p.integral = integral
+	}
+
+	p.terms = PIDTerms{
+		Error: e,
+		P:     p.kp * e,
+		I:     p.integral,
+		D:     p.kd * p.derivative,
+	}
+	terms := p.terms
+
+	return Decision{
+		DX:          obs.DX,
+		DY:          obs.DY,
+		Q:           obs.Q,
+		W:           obs.XmY - obs.Q,
+		B:           obs.Beta,
+		MaxBeta:     obs.MaxBeta,
+		Pending:     p.pending,
+		DR:          obs.DR,
+		DeadLetters: obs.DeadLetters,
+		Age:         obs.Age,
+		Branch:      PIDControl,
+		Bh:          output,
+		PID:         &terms,
+		Beta:        p.beta,
+	}, set
+}
+
+// Error between the time messages spend queued up and the max queue time, in
+// units. The time queued up is the age of the messages if the plant reports
+// it, or else it's estimated from Little's Law. Messages queued up with
+// nothing processed count as twice the max queue time.
+//
+// Either is taken for the queue the plant would have with the workers pending
+// running: Little's Law on the backlog they'd have left, and the age scaled
+// down with the queue, since they'd have taken the oldest messages.
+func (p *PID) queueError(obs Observation) float64 {
+	mq := float64(p.mq)
+
+	// Failed attempts are queued up again, so they take from the queue too.
+	attempts := obs.DY + obs.DR
+	backlog := float64(obs.Q)
+	if obs.Beta > 0 {
+		backlog = p.deadTime.backlog(obs.Q, attempts/float64(obs.Beta), p.t)
+	}
+
+	var queueTime float64
+	if obs.Age != nil {
+		queueTime = obs.Age.Oldest
+		if obs.Age.Percentile > 0 {
+			queueTime = math.Max(queueTime, obs.Age.Latency)
+		}
+		if obs.Q > 0 {
+			queueTime *= backlog / float64(obs.Q)
+		}
+	} else if obs.Q > 0 {
+		if attempts > 0 {
+			queueTime = backlog / attempts
+		} else {
+			queueTime = 2 * mq
+		}
+	}
+	return queueTime - mq
+}
+
+func (p *PID) clamp(output float64, maxBeta uint) float64 {
+	max := p.max
+	if maxBeta > 0 && (max == 0 || float64(maxBeta) < max) {
+		max = float64(maxBeta)
+	}
+	if max > 0 && output > max {
+		output = max
+	}
+	return math.Max(output, p.min)
+}
+
+// The getters below are safe to call while the controller runs; use Snapshot
+// for a consistent view.
+
+func (p *PID) Beta() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.beta
+}
+
+// Terms of the last output, and the error they were computed from.
+func (p *PID) Terms() PIDTerms {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.terms
+}
+
+// Snapshot of the state, with the estimates a PID controller doesn't make
+// left at zero.
+func (p *PID) Snapshot() Snapshot {
+	mu_p, ok := p.plant.MuP()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	mu_p = mup(p.obs, mu_p, ok)
+	var failureRate float64
+	if attempts := p.obs.DY + p.obs.DR; attempts > 0 {
+		failureRate = p.obs.DR / attempts
+	}
+	return Snapshot{
+		Iteration:         p.iteration,
+		Time:              p.time,
+		Branch:            p.branch,
+		Observation:       p.obs,
+		DX:                p.obs.DX,
+		DY:                p.obs.DY,
+		DR:                p.obs.DR,
+		Beta:              p.beta,
+		Pending:           p.pending,
+		ProvisioningDelay: p.deadTime.provisioningDelay(),
+		MuP:               mu_p,
+		FailureRate:       failureRate,
+		Failures:          p.failures,
+	}
+}
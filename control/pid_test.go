@@ -0,0 +1,147 @@
+package control
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPIDQueueTime(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		q     uint
+		dy    float64
+		dr    float64
+		age   *QueueAge
+		error float64
+	}{
+		// 20 messages at 10 per second wait for 2 seconds
+		{"little", 20, 10, 0, nil, 2 - 10},
+		{"retries", 20, 8, 2, nil, 2 - 10},
+		{"stuck", 20, 0, 0, nil, 10},
+		{"empty", 0, 0, 0, nil, -10},
+		{"oldest", 20, 10, 0, &QueueAge{Oldest: 15}, 5},
+		{"latency", 20, 10, 0, &QueueAge{Oldest: 4, Latency: 12, Percentile: 0.95}, 2},
+		{"latency unknown", 20, 10, 0, &QueueAge{Oldest: 4, Latency: 12}, -6},
+	} {
+		p := NewPID(newFakeManager(), 1, 10, time.Second, 1, 0, 0)
+		obs := Observation{Q: tc.q, DY: tc.dy, DR: tc.dr, Age: tc.age}
+		if e := p.queueError(obs); e != tc.error {
+			t.Errorf("%s: expected an error of %v, got %v", tc.name, tc.error, e)
+		}
+	}
+}
+
+func TestPIDBumplessStart(t *testing.T) {
+	m := newFakeManager()
+	p := NewPID(m, 1, 10, time.Second, 0.5, 0.1, 1)
+
+	if beta, set, _ := p.Step(); beta != float64(m.beta) || set {
+		t.Errorf("expected beta %d not to be set on the first iteration, got %v and %v", m.beta, beta, set)
+	}
+
+	// The error is constant, so only the integral moves.
+	beta, set, _ := p.Step()
+	if expected := float64(m.beta) + 0.1*(2-10); math.Abs(beta-expected) > 1e-9 || !set {
+		t.Errorf("expected beta %v to be set, got %v and %v", expected, beta, set)
+	}
+	if terms := p.Terms(); terms.Error != -8 || terms.D != 0 {
+		t.Errorf("unexpected terms %+v", terms)
+	}
+}
+
+func TestPIDAntiWindup(t *testing.T) {
+	m := newFakeManager()
+	m.dy = 0 // Stuck, the error is the max queue time
+	p := NewPID(m, 1, 10, time.Second, 0, 1, 0)
+	p.SetOutputLimits(1, 20)
+
+	for i := 0; i < 10; i++ {
+		p.Step()
+	}
+	if beta := p.Beta(); beta != 20 {
+		t.Fatalf("expected beta clamped to 20, got %v", beta)
+	}
+	if i := p.Terms().I; i > 20 {
+		t.Errorf("expected the integral not to wind up past the limit, got %v", i)
+	}
+
+	// Messages flow again, below the max queue time: beta drops at once.
+	m.dy = 10
+	if beta, _, _ := p.Step(); beta >= 20 {
+		t.Errorf("expected beta to drop from the limit, got %v", beta)
+	}
+
+	m.q = 0
+	for i := 0; i < 10; i++ {
+		p.Step()
+	}
+	if beta := p.Beta(); beta != 1 {
+		t.Errorf("expected beta clamped to 1, got %v", beta)
+	}
+}
+
+func TestPIDDerivativeFilter(t *testing.T) {
+	m := newFakeManager()
+	p := NewPID(m, 1, 10, time.Second, 0, 0, 1)
+	p.SetDerivativeFilter(0.75)
+	p.Step()
+
+	// The error goes up by 2 in a second, the derivative only by a quarter
+	// of that at first.
+	m.dy = 5
+	p.Step()
+	if d := p.Terms().D; d != 0.5 {
+		t.Errorf("expected a filtered derivative of 0.5, got %v", d)
+	}
+	p.Step()
+	if d := p.Terms().D; d != 0.375 {
+		t.Errorf("expected the derivative to decay to 0.375, got %v", d)
+	}
+}
+
+func TestPIDMaxBeta(t *testing.T) {
+	m := newFakeManager()
+	m.dy, m.maxBeta = 0, 3
+	p := NewPID(m, 1, 10, time.Second, 1, 0, 0)
+
+	p.Step()
+	if beta, _, _ := p.Step(); beta != 3 {
+		t.Errorf("expected beta capped to 3, got %v", beta)
+	}
+}
+
+func TestPIDRunsAsController(t *testing.T) {
+	var _ Controller = &Control{}
+	var _ Controller = &PID{}
+
+	m := newFakeManager()
+	p := NewPID(m, 1, 10, time.Second, 1, 0, 0)
+	records := []Decision{}
+	p.AddSink(SinkFunc(func(d Decision) {
+		records = append(records, d)
+	}))
+
+	p.Step()
+	p.Step()
+	if len(records) != 2 || records[1].Branch != PIDControl || records[1].PID == nil || !records[1].Set {
+		t.Errorf("expected PID decisions, got %+v", records)
+	}
+	if s := p.Snapshot(); s.Iteration != 2 || s.Branch != PIDControl || s.Beta != records[1].Beta {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+}
+
+// Plants that don't report MuP get it from Little's Law, as with Control.
+func TestSnapshotMuP(t *testing.T) {
+	for _, c := range []Controller{
+		NewControl(newFakeManager(), 1, 10, time.Second),
+		NewPID(newFakeManager(), 1, 10, time.Second, 1, 0.1, 0),
+		NewMPC(newFakeManager(), 1, 10, time.Second),
+	} {
+		c.Step()
+		if s := c.Snapshot(); s.MuP != 3 {
+			t.Errorf("%T: expected MuP 3 from 30 messages in the system at 10 per unit, got %v", c, s.MuP)
+		}
+	}
+}
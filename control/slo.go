@@ -0,0 +1,35 @@
+package control
+
+import "math"
+
+// Keep the time messages spend queued up under slo units, as reported by the
+// plant through Observation.Age, rather than only draining the queue in the
+// max queue time through Little's Law:
+//
+//   - Queued messages are drained before the oldest one has been waiting for
+//     slo, i.e. in the time it's got left, but no faster than in a control
+//     period.
+//   - If the latency reported is over slo even so, the workers are short: b
+//     is raised in proportion, up to double.
+//
+// Plants not reporting the age of their messages are controlled as usual.
+func (c *Control) SetLatencySLO(slo uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slo = slo
+}
+
+// Correct b and k so that messages don't wait for longer than the SLO. Must be
+// called with the lock held.
+func (c *Control) applySLO(age QueueAge, Q uint, branch Branch) {
+	slo := float64(c.slo)
+	if Q > 0 && c.r > 0 {
+		left := math.Max(slo-age.Oldest, float64(c.t))
+		c.k = math.Max(c.k, float64(Q)/c.r/left)
+	}
+
+	// Idle, b is meant to probe for R.
+	if age.Percentile > 0 && age.Latency > slo && branch != Idle {
+		c.b *= math.Min(age.Latency/slo, 2)
+	}
+}
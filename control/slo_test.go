@@ -0,0 +1,35 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencySLO(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		age  *QueueAge
+		slo  uint
+		b, k float64
+	}{
+		// 20 messages queued up at 2 per worker, to drain in 60 seconds
+		{"without age", nil, 10, 5, 20.0 / 2 / 60},
+		{"without SLO", &QueueAge{Oldest: 8}, 0, 5, 20.0 / 2 / 60},
+		// The oldest message has got 2 seconds left
+		{"oldest", &QueueAge{Oldest: 8}, 10, 5, 5},
+		// No faster than in a control period
+		{"late", &QueueAge{Oldest: 12}, 10, 5, 10},
+		{"latency", &QueueAge{Oldest: 1, Latency: 15, Percentile: 0.95}, 10, 7.5, 20.0 / 2 / 9},
+	} {
+		m := newFakeManager()
+		m.age = tc.age
+		c := NewControl(m, 1, 60, time.Second)
+		c.SetLatencySLO(tc.slo)
+
+		c.Step()
+		c.Step()
+		if s := c.Snapshot(); s.B != tc.b || s.K != tc.k {
+			t.Errorf("%s: expected b %v and k %v, got %v and %v", tc.name, tc.b, tc.k, s.B, s.K)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package control
+
+import "time"
+
+// Consistent copy of the controller's queryable state, as of the end of an
+// iteration.
+type Snapshot struct {
+	Iteration uint64
+	Time      time.Time
+	Branch    Branch
+
+	// Last successful observation of the plant
+	Observation Observation
+
+	DX, DY  float64
+	DR      float64
+	R, B, K float64
+	Beta    float64
+
+	// Projected cost per hour of beta, if there's a cost model
+	Cost float64
+
+	// Workers requested and not running yet, and the delay they're expected
+	// to take to start, in units
+	Pending           uint
+	ProvisioningDelay float64
+
+	XD                  uint
+	MuP                 float64
+	InternalConcurrency float64
+	FailureRate         float64
+
+	// Consecutive failures to sample the plant
+	Failures uint
+}
+
+func (c *Control) Snapshot() Snapshot {
+	mu_p, ok := c.plant.MuP()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	mu_p = mup(c.obs, mu_p, ok)
+	return Snapshot{
+		Iteration:           c.iteration,
+		Time:                c.time,
+		Branch:              c.branch,
+		Observation:         c.obs,
+		DX:                  c.dx,
+		DY:                  c.dy,
+		DR:                  c.dr,
+		R:                   c.r,
+		B:                   c.b,
+		K:                   c.k,
+		Beta:                c.beta(),
+		Cost:                c.cost(),
+		Pending:             c.pending,
+		ProvisioningDelay:   c.deadTime.provisioningDelay(),
+		XD:                  c.expected(mu_p),
+		MuP:                 mu_p,
+		InternalConcurrency: c.internalConcurrency.Value(),
+		FailureRate:         c.failureRate(),
+		Failures:            c.failures,
+	}
+}
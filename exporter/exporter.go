@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Publishes the state of a controller and its plant in the Prometheus text
+// exposition format, from the controller's snapshot. Errors sampling the plant
+// are counted from the decisions recorded by the controller, so the exporter
+// should be added as a sink:
+//
+//	e := exporter.NewExporter(c)
+//	c.AddSink(e)
+//	http.Handle("/metrics", e)
+type Exporter struct {
+	control   control.Controller
+	namespace string
+
+	// Counters
+	sampleErrors    uint64
+	actuationErrors uint64
+	sync.Mutex
+}
+
+func NewExporter(c control.Controller) *Exporter {
+	return &Exporter{
+		control:   c,
+		namespace: "queue_scaling",
+	}
+}
+
+// Prefix for every metric name, queue_scaling by default.
+func (e *Exporter) SetNamespace(namespace string) {
+	e.namespace = namespace
+}
+
+// Implements control.Sink
+func (e *Exporter) Record(d control.Decision) {
+	e.Lock()
+	defer e.Unlock()
+
+	if d.Branch == control.Failed {
+		e.sampleErrors++
+	}
+}
+
+// Count an error actuating on the plant. Suitable as an error handler for
+// actuators, such as sqs.ECSManager.SetErrorHandler.
+func (e *Exporter) ActuationError(err error) {
+	e.Lock()
+	e.actuationErrors++
+	e.Unlock()
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	e.Write(bw)
+	bw.Flush()
+}
+
+// Write every metric in the text exposition format.
+func (e *Exporter) Write(w io.Writer) {
+	e.Lock()
+	sampleErrors, actuationErrors := e.sampleErrors, e.actuationErrors
+	e.Unlock()
+
+	// The last successful observation of the plant is kept through failed
+	// iterations.
+	c := e.control.Snapshot()
+	obs := c.Observation
+
+	e.gauge(w, "arrival_rate", "Arrival rate (dx), per unit.", c.DX)
+	e.gauge(w, "departure_rate", "Departure rate (dy), per unit.", c.DY)
+	e.gauge(w, "retry_rate", "Rate of failed attempts to be retried or dead-lettered (dr), per unit.", c.DR)
+	e.gauge(w, "failure_ratio", "Fraction of the attempts to process messages that failed.", c.FailureRate)
+	e.gauge(w, "r", "Estimated throughput per worker (R), per unit.", c.R)
+	e.gauge(w, "b", "Estimated workers needed for the arrival rate (b).", c.B)
+	e.gauge(w, "k", "Workers added to drain the queue (k).", c.K)
+	e.gauge(w, "beta", "Beta computed by the controller.", c.Beta)
+	e.gauge(w, "projected_cost", "Projected cost per hour of beta, if there's a cost model.", c.Cost)
+	e.gauge(w, "provisioning_delay", "Time workers are expected to take to start, in units.", c.ProvisioningDelay)
+	e.gauge(w, "expected_messages", "Expected messages in the system (XD).", float64(c.XD))
+	e.gauge(w, "mu_p", "Mean processing time (MuP).", c.MuP)
+	e.gauge(w, "internal_concurrency", "Messages processed concurrently per worker.", c.InternalConcurrency)
+
+	e.header(w, "branch", "Branch taken by the controller on the last iteration.", "gauge")
+	for b := control.ColdStart; b <= control.Failed; b++ {
+		v := 0.0
+		if b == c.Branch && c.Iteration > 0 {
+			v = 1
+		}
+		fmt.Fprintf(w, "%s_branch{branch=%q} %s\n", e.namespace, b, formatFloat(v))
+	}
+
+	e.gauge(w, "plant_q", "Messages queued up in the plant (Q).", float64(obs.Q))
+	e.gauge(w, "plant_xmy", "Messages in the plant (X - Y).", float64(obs.XmY))
+	e.gauge(w, "plant_beta", "Workers running in the plant.", float64(obs.Beta))
+	e.gauge(w, "plant_pending", "Workers requested and not running yet in the plant.", float64(c.Pending))
+	var age control.QueueAge
+	if obs.Age != nil {
+		age = *obs.Age
+	}
+	e.gauge(w, "plant_oldest_age", "Age of the oldest message queued up in the plant, in units.", age.Oldest)
+	e.gauge(w, "plant_latency", "Time queued up of the messages taken lately, at the percentile reported by the plant, in units.", age.Latency)
+	e.gauge(w, "plant_dead_letters", "Messages in the dead-letter queue of the plant.", float64(obs.DeadLetters))
+
+	e.counter(w, "iterations_total", "Iterations of the control loop.", c.Iteration)
+	e.counter(w, "sample_errors_total", "Errors sampling the plant.", sampleErrors)
+	e.counter(w, "actuation_errors_total", "Errors setting beta on the plant.", actuationErrors)
+}
+
+func (e *Exporter) header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s_%s %s\n", e.namespace, name, help)
+	fmt.Fprintf(w, "# TYPE %s_%s %s\n", e.namespace, name, kind)
+}
+
+func (e *Exporter) gauge(w io.Writer, name, help string, v float64) {
+	e.header(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s_%s %s\n", e.namespace, name, formatFloat(v))
+}
+
+func (e *Exporter) counter(w io.Writer, name, help string, v uint64) {
+	e.header(w, name, help, "counter")
+	fmt.Fprintf(w, "%s_%s %d\n", e.namespace, name, v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,134 @@
+package exporter
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+type plant struct {
+	err error
+}
+
+func (p *plant) SetB() chan float64 {
+	return nil
+}
+
+func (p *plant) Sample(unit time.Duration) (control.Observation, error) {
+	return control.Observation{DX: 4, DY: 4, XmY: 12, Q: 10, Beta: 2, DR: 1, DeadLetters: 7,
+		Desired: 3, Pending: 1,
+		Age: &control.QueueAge{Oldest: 3, Latency: 2.5, Percentile: 0.95}}, p.err
+}
+
+func (p *plant) MuP() (float64, bool) {
+	return 500, true
+}
+
+func scrape(t *testing.T, url string) map[string]string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			t.Fatalf("malformed line %q", line)
+		}
+		metrics[line[:i]] = line[i+1:]
+	}
+	return metrics
+}
+
+func TestExporter(t *testing.T) {
+	p := &plant{}
+	c := control.NewControl(p, 1, 10, time.Second)
+	e := NewExporter(c)
+	c.AddSink(e)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	c.Step()
+	c.Step()
+	p.err = errors.New("throttled")
+	c.Step()
+	e.ActuationError(errors.New("throttled"))
+
+	expected := map[string]string{
+		"queue_scaling_arrival_rate":                  "4",
+		"queue_scaling_mu_p":                          "500",
+		"queue_scaling_branch{branch=\"failed\"}":     "1",
+		"queue_scaling_branch{branch=\"queued\"}":     "0",
+		"queue_scaling_plant_q":                       "10",
+		"queue_scaling_plant_xmy":                     "12",
+		"queue_scaling_plant_beta":                    "2",
+		"queue_scaling_plant_pending":                 "1",
+		"queue_scaling_iterations_total":              "3",
+		"queue_scaling_sample_errors_total":           "1",
+		"queue_scaling_actuation_errors_total":        "1",
+		"queue_scaling_branch{branch=\"cold_start\"}": "0",
+		"queue_scaling_internal_concurrency":          "1",
+		"queue_scaling_branch{branch=\"overscaled\"}": "0",
+		"queue_scaling_expected_messages":             "2",
+		"queue_scaling_retry_rate":                    "1",
+		"queue_scaling_failure_ratio":                 "0.2",
+		"queue_scaling_plant_dead_letters":            "7",
+		"queue_scaling_plant_oldest_age":              "3",
+		"queue_scaling_plant_latency":                 "2.5",
+	}
+
+	metrics := scrape(t, srv.URL+"/metrics")
+	for name, value := range expected {
+		if metrics[name] != value {
+			t.Errorf("%s: expected %s, got %q", name, value, metrics[name])
+		}
+	}
+	if _, ok := metrics["queue_scaling_beta"]; !ok {
+		t.Error("expected a beta gauge")
+	}
+}
+
+// Plant gauges come from the controller's snapshot, sink or not.
+func TestExporterWithoutSink(t *testing.T) {
+	c := control.NewControl(&plant{}, 1, 10, time.Second)
+	e := NewExporter(c)
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	c.Step()
+
+	metrics := scrape(t, srv.URL+"/metrics")
+	for name, value := range map[string]string{
+		"queue_scaling_plant_q":          "10",
+		"queue_scaling_plant_xmy":        "12",
+		"queue_scaling_plant_beta":       "2",
+		"queue_scaling_plant_pending":    "1",
+		"queue_scaling_plant_oldest_age": "3",
+		"queue_scaling_iterations_total": "1",
+	} {
+		if metrics[name] != value {
+			t.Errorf("%s: expected %s, got %q", name, value, metrics[name])
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package fakesqs serves in-memory SQS queues and their CloudWatch metrics
+// for the fake AWS clients in the tests of the sqs and sqsv2 packages, so that
+// both SDKs' fakes behave the same.
+package fakesqs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Url of every queue, followed by its name.
+const QueueUrl = "https://sqs.eu-west-1.amazonaws.com/123456789012/"
+
+// In-memory SQS queue, with its CloudWatch metrics per minute.
+type Queue struct {
+	Visible, NotVisible     int
+	Sent, Deleted, Received float64
+	RedrivePolicy           string
+
+	// Age of the oldest message, in seconds, none if zero
+	Age float64
+}
+
+// Queues by name.
+type Queues map[string]*Queue
+
+func (qs Queues) Queue(name string) (*Queue, error) {
+	q, ok := qs[name]
+	if !ok {
+		return nil, fmt.Errorf("AWS.SimpleQueueService.NonExistentQueue: %s", name)
+	}
+	return q, nil
+}
+
+// The attributes among names the queue has got.
+func (q *Queue) Attributes(names []string) map[string]string {
+	attrs := map[string]string{
+		"ApproximateNumberOfMessages":           strconv.Itoa(q.Visible),
+		"ApproximateNumberOfMessagesNotVisible": strconv.Itoa(q.NotVisible),
+	}
+	if q.RedrivePolicy != "" {
+		attrs["RedrivePolicy"] = q.RedrivePolicy
+	}
+
+	out := map[string]string{}
+	for _, name := range names {
+		if v, ok := attrs[name]; ok {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// Datapoints of metric for every period from start to end, the last one
+// incomplete, with the rates of the queue. The age of the oldest message is
+// missing if there's none, as with empty queues.
+func (q *Queue) Datapoints(metric string, period time.Duration, start, end time.Time) ([]time.Time, []float64, error) {
+	var v float64
+	switch metric {
+	case "ApproximateAgeOfOldestMessage":
+		if q.Age == 0 {
+			return nil, nil, nil
+		}
+		return []time.Time{start, end}, []float64{q.Age / 2, q.Age}, nil
+	case "NumberOfMessagesSent", "MessagesSent":
+		v = q.Sent
+	case "NumberOfMessagesDeleted", "MessagesDone":
+		v = q.Deleted
+	case "NumberOfMessagesReceived":
+		v = q.Received
+	default:
+		return nil, nil, fmt.Errorf("Unexpected metric %s", metric)
+	}
+
+	v *= float64(period) / float64(time.Minute)
+	var timestamps []time.Time
+	var values []float64
+	for t := end.Truncate(period); !t.Before(start); t = t.Add(-period) {
+		timestamps = append(timestamps, t)
+		if t.Add(period).After(end) {
+			values = append(values, v/2)
+		} else {
+			values = append(values, v)
+		}
+	}
+	return timestamps, values, nil
+}
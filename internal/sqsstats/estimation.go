@@ -0,0 +1,80 @@
+package sqsstats
+
+import (
+	"fmt"
+	"time"
+)
+
+// How an SQSManager estimates the arrival and departure rates. Failed attempts
+// are only estimated from CloudWatch.
+type Estimation int
+
+const (
+	// Rates from the CloudWatch metrics, see SetMetricPeriod. The default.
+	CloudWatch Estimation = iota
+
+	// Rates from successive samples of the queue attributes, plus the
+	// completions reported by the workers, without querying CloudWatch. The
+	// attributes only give away the net rate, dx - dy, so completions must
+	// be reported.
+	AttributeDelta
+
+	// Rates from the attributes and the completions reported by the workers
+	// when available, from CloudWatch otherwise. Without completions, dx is
+	// still estimated from the attributes on top of dy from CloudWatch, so
+	// that it doesn't lag behind.
+	Hybrid
+)
+
+func (e Estimation) String() string {
+	switch e {
+	case CloudWatch:
+		return "cloudwatch"
+	case AttributeDelta:
+		return "attribute_delta"
+	case Hybrid:
+		return "hybrid"
+	}
+	return fmt.Sprintf("Estimation(%d)", int(e))
+}
+
+// Rates from successive samples of messages in the system, X - Y, and the
+// messages completed in between them.
+type DeltaEstimator struct {
+	// Previous sample
+	xmy  uint
+	t    time.Time
+	seen bool
+
+	// Completed since the previous sample, and whether any were ever
+	// reported
+	completed uint64
+	reported  bool
+}
+
+// Report completed messages.
+func (d *DeltaEstimator) Complete(n uint) {
+	d.completed += uint64(n)
+	d.reported = true
+}
+
+// Take a sample at t, returning the net rate since the previous one, and the
+// rate of completions if reported. Returns ok false on the first sample.
+func (d *DeltaEstimator) Sample(xmy uint, t time.Time) (net, dy float64, hasDY, ok bool) {
+	prev, prevT, seen := d.xmy, d.t, d.seen
+	completed := d.completed
+
+	d.xmy, d.t, d.seen = xmy, t, true
+	d.completed = 0
+
+	dt := t.Sub(prevT).Seconds()
+	if !seen || dt <= 0 {
+		return 0, 0, false, false
+	}
+
+	net = (float64(xmy) - float64(prev)) / dt
+	if d.reported {
+		dy = float64(completed) / dt
+	}
+	return net, dy, d.reported, true
+}
@@ -0,0 +1,32 @@
+package sqsstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeltaEstimator(t *testing.T) {
+	var d DeltaEstimator
+	start := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	if _, _, _, ok := d.Sample(100, start); ok {
+		t.Error("expected no rates from the first sample")
+	}
+	net, _, hasDY, ok := d.Sample(120, start.Add(10*time.Second))
+	if !ok || hasDY || net != 2 {
+		t.Errorf("expected a net rate of 2 without completions, got %v, %v, %v", net, hasDY, ok)
+	}
+
+	d.Complete(30)
+	d.Complete(20)
+	net, dy, hasDY, ok := d.Sample(70, start.Add(20*time.Second))
+	if !ok || !hasDY || net != -5 || dy != 5 {
+		t.Errorf("expected a net rate of -5 and 5 completions per second, got %v, %v, %v, %v", net, dy, hasDY, ok)
+	}
+
+	// Once reported, a period without completions has none.
+	_, dy, hasDY, _ = d.Sample(70, start.Add(30*time.Second))
+	if !hasDY || dy != 0 {
+		t.Errorf("expected no completions, got %v, %v", dy, hasDY)
+	}
+}
@@ -0,0 +1,168 @@
+// Package sqsstats holds the logic behind the SQS managers that doesn't depend
+// on the version of the AWS SDK, shared by the sqs and sqsv2 packages: how
+// rates are taken from CloudWatch datapoints or estimated from attribute
+// deltas, and how dead-letter queues are found.
+package sqsstats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// How the rates are computed from the CloudWatch datapoints, which are sums
+// over the metric period.
+type Aggregation struct {
+	mode   int
+	points int
+}
+
+const (
+	lastComplete = iota
+	average
+	extrapolated
+)
+
+// Take the last complete datapoint, i.e. the next to last one. This is the
+// default, and lags behind by one to two periods.
+func LastComplete() Aggregation {
+	return Aggregation{mode: lastComplete}
+}
+
+// Take the average of the last n complete datapoints. Smoother, but lags
+// behind further.
+func Average(n int) Aggregation {
+	if n < 1 {
+		panic("n must be positive")
+	}
+	return Aggregation{mode: average, points: n}
+}
+
+// Take the last datapoint, which is likely incomplete, extrapolated to the
+// whole period. Doesn't lag, but it's noisy, and underestimates the rates if
+// CloudWatch hasn't ingested every datum in the period yet.
+func Extrapolated() Aggregation {
+	return Aggregation{mode: extrapolated}
+}
+
+// Datapoints needed before the last one.
+func (a Aggregation) complete() int {
+	switch a.mode {
+	case lastComplete:
+		return 1
+	case average:
+		return a.points
+	}
+	return 0
+}
+
+// Where the rates are taken from.
+type Metrics struct {
+	Namespace               string
+	Sent, Deleted, Received string // Metric names, Received is optional
+
+	Period, Lookback time.Duration
+	Aggregation      Aggregation
+}
+
+func DefaultMetrics() Metrics {
+	return Metrics{
+		Namespace:   "AWS/SQS",
+		Sent:        "NumberOfMessagesSent",
+		Deleted:     "NumberOfMessagesDeleted",
+		Received:    "NumberOfMessagesReceived",
+		Period:      time.Minute,
+		Lookback:    3 * time.Minute,
+		Aggregation: LastComplete(),
+	}
+}
+
+// Time to query metrics for, which is the lookback but enough to get every
+// point needed for the aggregation.
+func (mc Metrics) Window() time.Duration {
+	needed := time.Duration(mc.Aggregation.complete()+2) * mc.Period
+	if mc.Lookback < needed {
+		return needed
+	}
+	return mc.Lookback
+}
+
+// Rate per second from the datapoints of a metric, as of now.
+func (mc Metrics) Rate(timestamps []time.Time, values []float64, now time.Time) (float64, error) {
+	if len(values) != len(timestamps) {
+		return 0, fmt.Errorf("Got %d values for %d timestamps", len(values), len(timestamps))
+	}
+
+	// Newest first
+	idx := make([]int, len(timestamps))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return timestamps[idx[i]].After(timestamps[idx[j]])
+	})
+
+	a := mc.Aggregation
+	needed := a.complete() + 1
+	if len(idx) < needed {
+		return 0, fmt.Errorf("Expected at least %d metrics, got %d", needed, len(idx))
+	}
+
+	period := mc.Period.Seconds()
+	switch a.mode {
+	case extrapolated:
+		// Timestamps are the start of the period
+		elapsed := now.Sub(timestamps[idx[0]]).Seconds()
+		if elapsed > period {
+			elapsed = period
+		} else if elapsed < 1 {
+			elapsed = 1
+		}
+		return values[idx[0]] / elapsed, nil
+
+	default:
+		var sum float64
+		for _, i := range idx[1:needed] {
+			sum += values[i]
+		}
+		return sum / float64(a.complete()) / period, nil
+	}
+}
+
+// Newest value of a metric.
+func Latest(timestamps []time.Time, values []float64) (float64, error) {
+	if len(values) == 0 || len(values) != len(timestamps) {
+		return 0, fmt.Errorf("Expected at least one metric, got %d", len(values))
+	}
+
+	last := 0
+	for i := range timestamps {
+		if timestamps[i].After(timestamps[last]) {
+			last = i
+		}
+	}
+	return values[last], nil
+}
+
+// Periods supported by GetMetricData: 1, 5, 10 or 30 seconds for
+// high-resolution metrics, or a multiple of a minute.
+func CheckPeriod(period time.Duration) {
+	if period%time.Second != 0 {
+		panic("period must be a whole number of seconds")
+	}
+	switch s := period / time.Second; {
+	case s == 1, s == 5, s == 10, s == 30:
+	case s > 0 && s%60 == 0:
+	default:
+		panic("period must be 1, 5, 10, 30 seconds or a multiple of a minute")
+	}
+}
+
+func (mc *Metrics) SetPeriod(period time.Duration) {
+	CheckPeriod(period)
+	mc.Period = period
+}
+
+func (mc *Metrics) SetCustom(namespace, sent, deleted, received string) {
+	mc.Namespace, mc.Sent, mc.Deleted, mc.Received = namespace, sent, deleted, received
+}
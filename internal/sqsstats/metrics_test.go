@@ -0,0 +1,69 @@
+package sqsstats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	start := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+	// Out of order, as CloudWatch doesn't guarantee any, with the last
+	// minute 15 seconds in.
+	timestamps := []time.Time{
+		start.Add(2 * time.Minute),
+		start,
+		start.Add(3 * time.Minute),
+		start.Add(time.Minute),
+	}
+	values := []float64{120, 240, 30, 60}
+	now := start.Add(3*time.Minute + 15*time.Second)
+
+	for _, tc := range []struct {
+		name        string
+		aggregation Aggregation
+		rate        float64
+	}{
+		{"last complete", LastComplete(), 2},
+		{"average", Average(3), 7.0 / 3},
+		{"extrapolated", Extrapolated(), 2},
+	} {
+		mc := DefaultMetrics()
+		mc.Aggregation = tc.aggregation
+		rate, err := mc.Rate(timestamps, values, now)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		} else if math.Abs(rate-tc.rate) > 1e-9 {
+			t.Errorf("%s: expected a rate of %v, got %v", tc.name, tc.rate, rate)
+		}
+	}
+
+	mc := DefaultMetrics()
+	mc.Aggregation = Average(4)
+	if _, err := mc.Rate(timestamps, values, now); err == nil {
+		t.Error("expected an error averaging more points than available")
+	}
+	if w := mc.Window(); w != 6*time.Minute {
+		t.Errorf("expected the lookback to extend to 6m, got %v", w)
+	}
+
+	if v, err := Latest(timestamps, values); err != nil || v != 30 {
+		t.Errorf("expected the latest value to be 30, got %v, %v", v, err)
+	}
+}
+
+func TestCheckPeriod(t *testing.T) {
+	for _, p := range []time.Duration{time.Second, 30 * time.Second, time.Minute, 5 * time.Minute} {
+		CheckPeriod(p)
+	}
+	for _, p := range []time.Duration{0, 1500 * time.Millisecond, 20 * time.Second, 90 * time.Second} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %v to be rejected", p)
+				}
+			}()
+			CheckPeriod(p)
+		}()
+	}
+}
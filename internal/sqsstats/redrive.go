@@ -0,0 +1,31 @@
+package sqsstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ARN of the dead-letter queue in policy, a JSON redrive policy, or none if
+// empty.
+func DeadLetterTarget(policy string) (string, error) {
+	var rp struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	}
+	if policy != "" {
+		if err := json.Unmarshal([]byte(policy), &rp); err != nil {
+			return "", fmt.Errorf("Error parsing redrive policy: %s", err)
+		}
+	}
+	return rp.DeadLetterTargetArn, nil
+}
+
+// Name and owning account of the queue with the given ARN.
+func ParseQueueARN(arn string) (name, owner string, err error) {
+	// arn:aws:sqs:region:account:name
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[2] != "sqs" {
+		return "", "", fmt.Errorf("Unexpected queue ARN %s", arn)
+	}
+	return parts[5], parts[4], nil
+}
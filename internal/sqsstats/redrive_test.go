@@ -0,0 +1,24 @@
+package sqsstats
+
+import "testing"
+
+func TestDeadLetterTarget(t *testing.T) {
+	arn, err := DeadLetterTarget(`{"deadLetterTargetArn":"arn:aws:sqs:eu-west-1:123456789012:jobs-dlq","maxReceiveCount":5}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, owner, err := ParseQueueARN(arn)
+	if err != nil || name != "jobs-dlq" || owner != "123456789012" {
+		t.Errorf("unexpected queue %s owned by %s, %v", name, owner, err)
+	}
+
+	if arn, err := DeadLetterTarget(""); err != nil || arn != "" {
+		t.Errorf("expected no dead-letter queue without a policy, got %q, %v", arn, err)
+	}
+	if _, err := DeadLetterTarget("{"); err == nil {
+		t.Error("expected an error parsing a broken policy")
+	}
+	if _, _, err := ParseQueueARN("arn:aws:sns:eu-west-1:123456789012:jobs"); err == nil {
+		t.Error("expected an error parsing a topic ARN")
+	}
+}
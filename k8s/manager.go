@@ -0,0 +1,181 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Scales a Deployment or StatefulSet through its scale subresource, as
+// ECSManager does for ECS services. Implements sqs.SQSControlManager.
+type Manager struct {
+	setB chan float64
+
+	config    *Config
+	namespace string
+	resource  string // deployments or statefulsets
+	name      string
+	min, max  int64
+
+	// Called on errors scaling, besides logging them
+	onError func(error)
+}
+
+// Timeout for every request to the API server.
+const requestTimeout = 30 * time.Second
+
+func NewDeploymentManager(config *Config, namespace, name string) *Manager {
+	return newManager(config, namespace, "deployments", name)
+}
+
+func NewStatefulSetManager(config *Config, namespace, name string) *Manager {
+	return newManager(config, namespace, "statefulsets", name)
+}
+
+func newManager(config *Config, namespace, resource, name string) *Manager {
+	m := &Manager{
+		setB:      make(chan float64),
+		config:    config,
+		namespace: namespace,
+		resource:  resource,
+		name:      name,
+	}
+
+	go m.run()
+
+	return m
+}
+
+func (m *Manager) run() {
+	for b := range m.setB {
+		v := int64(math.Round(b))
+		if m.min > 0 && v < m.min {
+			v = m.min
+		} else if m.max > 0 && v > m.max {
+			v = m.max
+		}
+		if err := m.updateB(v); err != nil {
+			log.Printf("Error scaling %s %s/%s: %s", m.resource, m.namespace, m.name, err)
+			if m.onError != nil {
+				m.onError(err)
+			}
+		}
+	}
+}
+
+func (m *Manager) SetLimits(min, max int64) {
+	if max > 0 && min > max {
+		panic("min > max")
+	}
+	m.min = min
+	m.max = max
+}
+
+// Set a function to be called with every error scaling, e.g. to count them.
+// Must be called before beta is first set.
+func (m *Manager) SetErrorHandler(f func(error)) {
+	m.onError = f
+}
+
+func (m *Manager) SetB() chan float64 {
+	return m.setB
+}
+
+// Number of ready replicas.
+func (m *Manager) Beta() (uint, error) {
+	ready, _, err := m.replicas()
+	return ready, err
+}
+
+// Implements control.PendingActuator: replicas pending are those desired
+// that aren't ready yet.
+func (m *Manager) Workers() (running, desired, pending uint, err error) {
+	running, desired, err = m.replicas()
+	if desired > running {
+		pending = desired - running
+	}
+	return running, desired, pending, err
+}
+
+// Ready and desired replicas.
+func (m *Manager) replicas() (ready, desired uint, err error) {
+	var obj struct {
+		Spec struct {
+			Replicas int64 `json:"replicas"`
+		} `json:"spec"`
+		Status struct {
+			ReadyReplicas int64 `json:"readyReplicas"`
+		} `json:"status"`
+	}
+	if err := m.do(http.MethodGet, m.path(), "", nil, &obj); err != nil {
+		return 0, 0, err
+	}
+	return uint(obj.Status.ReadyReplicas), uint(obj.Spec.Replicas), nil
+}
+
+func (m *Manager) updateB(b int64) error {
+	patch := map[string]any{
+		"spec": map[string]any{"replicas": b},
+	}
+	return m.do(http.MethodPatch, m.path()+"/scale", "application/merge-patch+json", patch, nil)
+}
+
+func (m *Manager) path() string {
+	return fmt.Sprintf("/apis/apps/v1/namespaces/%s/%s/%s",
+		url.PathEscape(m.namespace), m.resource, url.PathEscape(m.name))
+}
+
+// Send a request to the API server, encoding in and decoding the response
+// into out, if given.
+func (m *Manager) do(method, path, contentType string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, m.config.Host+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if m.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.config.Token)
+	}
+
+	resp, err := m.config.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("Error querying Kubernetes: %s %s: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Error querying Kubernetes: %s %s: %s: %s",
+			method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("Error parsing Kubernetes response: %s", err)
+	}
+	return nil
+}
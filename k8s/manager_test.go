@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/sqs"
+)
+
+var _ sqs.SQSControlManager = (*Manager)(nil)
+
+// Fake API server with a single deployment, reporting every scale.
+func fakeAPIServer(t *testing.T, ready int64, scaled chan int64) *httptest.Server {
+	const path = "/apis/apps/v1/namespaces/workers/deployments/consumer"
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == path:
+			json.NewEncoder(w).Encode(map[string]any{
+				"spec":   map[string]any{"replicas": ready + 2},
+				"status": map[string]any{"replicas": ready + 1, "readyReplicas": ready},
+			})
+
+		case r.Method == http.MethodPatch && r.URL.Path == path+"/scale":
+			if ct := r.Header.Get("Content-Type"); ct != "application/merge-patch+json" {
+				t.Errorf("unexpected content type %s", ct)
+			}
+			var patch struct {
+				Spec struct {
+					Replicas int64 `json:"replicas"`
+				} `json:"spec"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(patch)
+			scaled <- patch.Spec.Replicas
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestDeploymentManager(t *testing.T) {
+	scaled := make(chan int64, 1)
+	srv := fakeAPIServer(t, 3, scaled)
+	defer srv.Close()
+
+	m := NewDeploymentManager(&Config{Host: srv.URL, Token: "secret"}, "workers", "consumer")
+	m.SetLimits(2, 10)
+
+	beta, err := m.Beta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if beta != 3 {
+		t.Errorf("expected 3 ready replicas, got %d", beta)
+	}
+	if running, desired, pending, err := m.Workers(); err != nil || running != 3 || desired != 5 || pending != 2 {
+		t.Errorf("expected 3 replicas running of 5, 2 pending; got %d, %d and %d (%v)", running, desired, pending, err)
+	}
+
+	for _, c := range []struct {
+		b        float64
+		replicas int64
+	}{
+		{4.4, 4},
+		{0, 2},
+		{25, 10},
+	} {
+		m.SetB() <- c.b
+		select {
+		case r := <-scaled:
+			if r != c.replicas {
+				t.Errorf("beta %v: expected %d replicas, got %d", c.b, c.replicas, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("beta %v: timed out waiting to scale", c.b)
+		}
+	}
+}
+
+func TestManagerErrors(t *testing.T) {
+	srv := fakeAPIServer(t, 3, nil)
+	defer srv.Close()
+
+	errs := make(chan error, 1)
+	m := NewStatefulSetManager(&Config{Host: srv.URL, Token: "secret"}, "workers", "consumer")
+	m.SetErrorHandler(func(err error) { errs <- err })
+
+	if _, err := m.Beta(); err == nil {
+		t.Error("expected an error for a missing statefulset")
+	}
+
+	m.SetB() <- 1
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the scaling error")
+	}
+}
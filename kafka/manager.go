@@ -0,0 +1,201 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Offsets of a partition, as seen by a consumer group.
+type PartitionOffsets struct {
+	Topic     string
+	Partition int32
+
+	LogEnd    int64 // Offset of the next message to be produced
+	Committed int64 // Offset of the next message to be consumed by the group
+}
+
+// Source of the offsets of every partition consumed by a group, usually
+// implemented on top of a Kafka client's admin API (ListOffsets and
+// OffsetFetch).
+type OffsetSource interface {
+	Offsets(ctx context.Context) ([]PartitionOffsets, error)
+}
+
+// Implements the control.Manager interface for a Kafka consumer group. XmY is
+// the group's lag, summed across partitions, and the rates are the growth of
+// the log-end and committed offsets. Since offsets are only committed once
+// messages are processed, messages being worked on are part of the lag. Kafka
+// doesn't tell them apart, so Q is estimated as the lag besides a message in
+// process on every partition lagging, as many as consumers there are.
+//
+// A partition is consumed by at most one consumer of the group, so beta is
+// capped at the number of partitions.
+type Manager struct {
+	ctx    context.Context
+	source OffsetSource
+	name   string // For errors
+
+	// Last offsets by partition, to compute the rates from
+	offsets   map[partition]PartitionOffsets
+	timestamp time.Time
+
+	// Stats, rates per second; lagging are the partitions with lag
+	dx, dy     float64
+	lag        uint
+	lagging    uint
+	partitions uint
+
+	// Stats have errored, returned when sampled
+	err error
+
+	// Guards stats and err, which are updated in the background
+	sync.Mutex
+
+	control control.Actuator
+}
+
+type partition struct {
+	topic     string
+	partition int32
+}
+
+// Timeout for querying the offsets.
+const requestTimeout = 30 * time.Second
+
+// Stats are updated in the background until ctx is done. The source is left
+// open, to be closed by its owner.
+func NewManager(ctx context.Context, source OffsetSource, name string, updatePeriod time.Duration, control control.Actuator) *Manager {
+	m := &Manager{
+		ctx:     ctx,
+		source:  source,
+		name:    name,
+		control: control,
+	}
+
+	m.setErr(m.updateStats())
+	go m.run(updatePeriod)
+
+	return m
+}
+
+func (m *Manager) run(updatePeriod time.Duration) {
+	t := time.NewTicker(updatePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.setErr(m.ctx.Err())
+			return
+		case <-t.C:
+			m.setErr(m.updateStats())
+		}
+	}
+}
+
+func (m *Manager) setErr(err error) {
+	m.Lock()
+	m.err = err
+	m.Unlock()
+}
+
+func (m *Manager) updateStats() error {
+	ctx, cancel := context.WithTimeout(m.ctx, requestTimeout)
+	defer cancel()
+
+	now := time.Now()
+	offsets, err := m.source.Offsets(ctx)
+	if err != nil {
+		return fmt.Errorf("Error querying offsets for %s: %s", m.name, err)
+	}
+
+	var lag, lagging uint
+	var produced, consumed int64
+	current := make(map[partition]PartitionOffsets, len(offsets))
+	for _, o := range offsets {
+		p := partition{o.Topic, o.Partition}
+		current[p] = o
+
+		if o.LogEnd > o.Committed {
+			lag += uint(o.LogEnd - o.Committed)
+			lagging++
+		}
+
+		// Partitions that are new, or whose offsets went backwards (e.g.
+		// reset by an operator) don't account for the rates this time.
+		if prev, ok := m.offsets[p]; ok {
+			if o.LogEnd > prev.LogEnd {
+				produced += o.LogEnd - prev.LogEnd
+			}
+			if o.Committed > prev.Committed {
+				consumed += o.Committed - prev.Committed
+			}
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.offsets != nil {
+		elapsed := now.Sub(m.timestamp).Seconds()
+		if elapsed > 0 {
+			m.dx, m.dy = float64(produced)/elapsed, float64(consumed)/elapsed
+		}
+	}
+	m.offsets, m.timestamp = current, now
+	m.lag, m.lagging = lag, lagging
+	m.partitions = uint(len(offsets))
+
+	return nil
+}
+
+// Most consumers the group can make use of.
+func (m *Manager) MaxBeta() uint {
+	m.Lock()
+	defer m.Unlock()
+	return m.partitions
+}
+
+func (m *Manager) SetB() chan float64 {
+	return m.control.SetB()
+}
+
+func (m *Manager) MuP() (float64, bool) {
+	return 0, false
+}
+
+func (m *Manager) Sample(unit time.Duration) (control.Observation, error) {
+	beta, desired, pending, err := control.QueryWorkers(m.control)
+	if err != nil {
+		return control.Observation{}, fmt.Errorf("Error querying actuator for %s: %s", m.name, err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return control.Observation{}, m.err
+	}
+
+	// A consumer processes a message at a time from each of its partitions.
+	inProcess := m.lagging
+	if beta < inProcess {
+		inProcess = beta
+	}
+
+	factor := float64(time.Second) / float64(unit)
+	return control.Observation{
+		DX:      m.dx / factor,
+		DY:      m.dy / factor,
+		XmY:     m.lag,
+		Q:       m.lag - inProcess,
+		Beta:    beta,
+		Desired: desired,
+		Pending: pending,
+		MaxBeta: m.partitions,
+	}, nil
+}
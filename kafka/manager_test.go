@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Offsets kept in memory.
+type fakeSource struct {
+	offsets []PartitionOffsets
+	err     error
+	sync.Mutex
+}
+
+func (f *fakeSource) Offsets(ctx context.Context) ([]PartitionOffsets, error) {
+	f.Lock()
+	defer f.Unlock()
+	return append([]PartitionOffsets(nil), f.offsets...), f.err
+}
+
+func (f *fakeSource) set(offsets []PartitionOffsets, err error) {
+	f.Lock()
+	f.offsets, f.err = offsets, err
+	f.Unlock()
+}
+
+type actuator struct {
+	beta uint
+}
+
+func (a *actuator) SetB() chan float64 {
+	return nil
+}
+
+func (a *actuator) Beta() (uint, error) {
+	return a.beta, nil
+}
+
+func TestManager(t *testing.T) {
+	source := &fakeSource{offsets: []PartitionOffsets{
+		{"orders", 0, 100, 90},
+		{"orders", 1, 200, 200},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewManager(ctx, source, "orders", time.Hour, &actuator{beta: 1})
+
+	source.set([]PartitionOffsets{
+		{"orders", 0, 150, 120},
+		{"orders", 1, 250, 240},
+		// New partition, not accounted for in the rates until next time
+		{"orders", 2, 1000, 0},
+	}, nil)
+	m.Lock()
+	m.timestamp = m.timestamp.Add(-10 * time.Second)
+	m.Unlock()
+	m.setErr(m.updateStats())
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(obs.DX-10) > 0.1 || math.Abs(obs.DY-7) > 0.1 {
+		t.Errorf("expected rates of 10 and 7 per second, got %v and %v", obs.DX, obs.DY)
+	}
+	// The one consumer is processing a message from one of the partitions.
+	if obs.Q != 1039 || obs.XmY != 1040 || obs.MaxBeta != 3 || m.MaxBeta() != 3 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	source.set(nil, errors.New("coordinator not available"))
+	m.setErr(m.updateStats())
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error after failing to fetch offsets")
+	}
+}
+
+// A group keeping up with its input, with a message in process on every
+// partition, is in equilibrium rather than idle.
+func TestControlSteadyLag(t *testing.T) {
+	offsets := func(i int64) []PartitionOffsets {
+		var o []PartitionOffsets
+		for p := int32(0); p < 4; p++ {
+			o = append(o, PartitionOffsets{"orders", p, 100*i + 1, 100 * i})
+		}
+		return o
+	}
+
+	source := &fakeSource{offsets: offsets(0)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewManager(ctx, source, "orders", time.Hour, &actuator{beta: 4})
+	c := control.NewControl(m, 10, 1, time.Second)
+
+	var d control.Decision
+	c.AddSink(control.SinkFunc(func(r control.Decision) { d = r }))
+	for i := int64(1); i <= 10; i++ {
+		source.set(offsets(i), nil)
+		m.Lock()
+		m.timestamp = m.timestamp.Add(-10 * time.Second)
+		m.Unlock()
+		m.setErr(m.updateStats())
+
+		if _, _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if d.Q != 0 || d.W != 4 || d.Branch != control.Overscaled {
+		t.Errorf("expected 4 messages in process and none queued, got %+v", d)
+	}
+	if math.Abs(d.Bh-4) > 0.1 {
+		t.Errorf("expected the 4 consumers to be kept, got %v", d.Bh)
+	}
+}
+
+func TestManagerStops(t *testing.T) {
+	source := &fakeSource{offsets: []PartitionOffsets{{"orders", 0, 100, 90}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx, source, "orders", time.Millisecond, &actuator{})
+	cancel()
+
+	// Once stopped, samples report why.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		_, err := m.Sample(time.Second)
+		if errors.Is(err, context.Canceled) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v once stopped, got %v", context.Canceled, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,160 @@
+// Package policy limits how beta is actuated on: how much and how often the
+// workers of a plant are scaled, whatever the controller asks for.
+package policy
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Limits on changes to the workers. Zero values don't limit.
+type Policy struct {
+	// Most workers added or removed on a single change. If both an absolute
+	// step and a ratio of the current workers are set, the larger step is
+	// allowed, so that the absolute one works as a minimum when there are
+	// few workers.
+	MaxStepUp, MaxStepDown           float64
+	MaxStepUpRatio, MaxStepDownRatio float64
+
+	// Time after scaling up before scaling up again, and after any change
+	// before scaling down, so that workers just started get the chance to
+	// show their effect.
+	UpCooldown, DownCooldown time.Duration
+
+	// Changes of fewer workers than this are ignored, to avoid flapping
+	// around a value, except to and from zero workers.
+	Deadband float64
+}
+
+// Wraps an actuator, applying a policy to the beta set on it. Only whole
+// changes are sent, so the wrapped actuator doesn't see redundant values.
+// Goes between a manager and its actuator:
+//
+//	a := policy.NewActuator(sqs.NewECSManager(cluster, service), p)
+//	m := sqs.NewSQSManager(queue, time.Minute, a)
+type Actuator struct {
+	actuator control.Actuator
+	policy   Policy
+	setB     chan float64
+	clock    clock.Clock
+
+	// Workers the wrapped actuator is at, whether it's known, and when it
+	// last changed in every direction
+	current         float64
+	known           bool
+	lastUp, lastAny time.Time
+
+	sync.Mutex
+}
+
+func NewActuator(actuator control.Actuator, policy Policy) *Actuator {
+	a := &Actuator{
+		actuator: actuator,
+		policy:   policy,
+		setB:     make(chan float64),
+		clock:    clock.Real{},
+	}
+
+	go a.run()
+
+	return a
+}
+
+func (a *Actuator) run() {
+	for b := range a.setB {
+		if v, ok := a.next(b); ok {
+			a.actuator.SetB() <- v
+		}
+	}
+}
+
+// Use clk instead of the wall clock. Must be called before beta is first set.
+func (a *Actuator) SetClock(clk clock.Clock) {
+	a.clock = clk
+}
+
+// Workers to set when beta b is asked for, if they should be set at all.
+func (a *Actuator) next(b float64) (float64, bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	// Start from the workers the wrapped actuator wants, re-read on every
+	// decision since it may clamp what it's set, or be scaled by something
+	// else. Actuators that don't report them are taken to be at the last
+	// beta set, starting from the workers running.
+	running, desired, _, err := control.QueryWorkers(a.actuator)
+	switch {
+	case err != nil && !a.known:
+		log.Printf("Error querying actuator, applying no policy: %s", err)
+		return b, true
+	case err != nil:
+		log.Printf("Error querying actuator, taking beta as %v: %s", a.current, err)
+	case desired > 0:
+		a.current, a.known = float64(desired), true
+	case !a.known:
+		a.current, a.known = float64(running), true
+	}
+
+	now := a.clock.Now()
+	p := a.policy
+	target := math.Round(b)
+	diff := target - a.current
+
+	// Starting and stopping the plant aren't flapping: the first worker must
+	// start for messages to be processed at all, and the last one stop for
+	// the plant to scale to zero.
+	fromOrToZero := a.current == 0 || target == 0
+	if diff == 0 || (math.Abs(diff) < p.Deadband && !fromOrToZero) {
+		return 0, false
+	}
+
+	if diff > 0 {
+		if !a.lastUp.IsZero() && now.Sub(a.lastUp) < p.UpCooldown {
+			return 0, false
+		}
+		if step := maxStep(p.MaxStepUp, p.MaxStepUpRatio, a.current); step > 0 && diff > step {
+			target = a.current + step
+		}
+		a.lastUp = now
+	} else {
+		if !a.lastAny.IsZero() && now.Sub(a.lastAny) < p.DownCooldown {
+			return 0, false
+		}
+		if step := maxStep(p.MaxStepDown, p.MaxStepDownRatio, a.current); step > 0 && -diff > step {
+			target = a.current - step
+		}
+	}
+
+	a.current, a.lastAny = target, now
+	return target, true
+}
+
+// Largest step allowed from current workers, in whole workers, or zero for no
+// limit.
+func maxStep(step, ratio, current float64) float64 {
+	if step == 0 && ratio == 0 {
+		return 0
+	}
+	step = math.Max(step, ratio*current)
+	// At least one worker, so that scaling can't get stuck.
+	return math.Max(math.Floor(step), 1)
+}
+
+func (a *Actuator) SetB() chan float64 {
+	return a.setB
+}
+
+func (a *Actuator) Beta() (uint, error) {
+	return a.actuator.Beta()
+}
+
+// Implements control.PendingActuator, with the workers pending on the wrapped
+// actuator if it reports them.
+func (a *Actuator) Workers() (running, desired, pending uint, err error) {
+	return control.QueryWorkers(a.actuator)
+}
@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+)
+
+type fakeActuator struct {
+	setB chan float64
+	beta uint
+	err  error
+}
+
+func (a *fakeActuator) SetB() chan float64 {
+	return a.setB
+}
+
+func (a *fakeActuator) Beta() (uint, error) {
+	return a.beta, a.err
+}
+
+// Beta asked for after some time since the previous one, and what's expected
+// to be set, if anything.
+type step struct {
+	after time.Duration
+	b     float64
+	set   bool
+	v     float64
+}
+
+func TestNext(t *testing.T) {
+	start := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		beta   uint // Workers running to start from
+		steps  []step
+	}{
+		{
+			name:   "no policy",
+			beta:   4,
+			policy: Policy{},
+			steps: []step{
+				{0, 10.4, true, 10},
+				{time.Minute, 9.6, false, 0},
+				{time.Minute, 2, true, 2},
+			},
+		},
+		{
+			name:   "steps",
+			beta:   4,
+			policy: Policy{MaxStepUp: 2, MaxStepUpRatio: 0.5, MaxStepDown: 1},
+			steps: []step{
+				{0, 20, true, 6},
+				{time.Minute, 20, true, 9},
+				{time.Minute, 0, true, 8},
+			},
+		},
+		{
+			name:   "cooldowns",
+			beta:   4,
+			policy: Policy{UpCooldown: 3 * time.Minute, DownCooldown: 5 * time.Minute},
+			steps: []step{
+				{0, 8, true, 8},
+				{time.Minute, 10, false, 0},
+				{time.Minute, 6, false, 0},
+				{time.Minute, 10, true, 10},
+				{4 * time.Minute, 6, false, 0},
+				{time.Minute, 6, true, 6},
+				{time.Minute, 12, true, 12},
+			},
+		},
+		{
+			name:   "deadband",
+			beta:   4,
+			policy: Policy{Deadband: 2},
+			steps: []step{
+				{0, 5, false, 0},
+				{time.Minute, 2, true, 2},
+				{time.Minute, 3, false, 0},
+				{time.Minute, 4, true, 4},
+			},
+		},
+		{
+			name:   "deadband from and to zero",
+			policy: Policy{Deadband: 2},
+			beta:   0,
+			steps: []step{
+				{0, 1, true, 1},
+				{time.Minute, 2, false, 0},
+				{time.Minute, 0, true, 0},
+			},
+		},
+	} {
+		clk := clock.NewFake(start)
+		a := &Actuator{actuator: &fakeActuator{beta: tc.beta}, policy: tc.policy, clock: clk}
+		for i, s := range tc.steps {
+			clk.Advance(s.after)
+			v, set := a.next(s.b)
+			if set != s.set || v != s.v {
+				t.Errorf("%s, step %d: expected %v, %v, got %v, %v", tc.name, i, s.v, s.set, v, set)
+			}
+		}
+	}
+}
+
+// Actuator with limits, reporting the workers it wants as clamped.
+type clampingActuator struct {
+	fakeActuator
+	desired, max uint
+}
+
+func (a *clampingActuator) Workers() (running, desired, pending uint, err error) {
+	return a.beta, a.desired, 0, a.err
+}
+
+func (a *clampingActuator) set(b float64) {
+	a.desired = uint(b)
+	if a.desired > a.max {
+		a.desired = a.max
+	}
+}
+
+func TestNextAfterClamping(t *testing.T) {
+	clk := clock.NewFake(time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC))
+	inner := &clampingActuator{fakeActuator: fakeActuator{beta: 4}, desired: 4, max: 6}
+	a := &Actuator{actuator: inner, policy: Policy{MaxStepDown: 1}, clock: clk}
+
+	v, set := a.next(10)
+	if !set || v != 10 {
+		t.Fatalf("expected 10 set, got %v, %v", v, set)
+	}
+	inner.set(v)
+
+	// Stepping down from the 6 workers the actuator clamped to, not from 10.
+	clk.Advance(time.Minute)
+	if v, set := a.next(5); !set || v != 5 {
+		t.Errorf("expected 5 set, got %v, %v", v, set)
+	}
+
+	// Nothing to set if something else scaled the actuator to the beta asked
+	// for.
+	inner.desired = 6
+	clk.Advance(time.Minute)
+	if v, set := a.next(6); set {
+		t.Errorf("expected nothing set at the workers desired, got %v", v)
+	}
+}
+
+func TestActuator(t *testing.T) {
+	inner := &fakeActuator{setB: make(chan float64, 4), beta: 4}
+	a := NewActuator(inner, Policy{MaxStepUp: 1})
+
+	// The second value is redundant.
+	for _, b := range []float64{6.2, 5.4, 9} {
+		a.SetB() <- b
+	}
+
+	for _, expected := range []float64{5, 6} {
+		select {
+		case b := <-inner.setB:
+			if b != expected {
+				t.Errorf("expected beta %v, got %v", expected, b)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for beta")
+		}
+	}
+
+	if beta, _ := a.Beta(); beta != 4 {
+		t.Errorf("expected beta from the wrapped actuator, got %d", beta)
+	}
+}
+
+func TestActuatorWithoutBeta(t *testing.T) {
+	inner := &fakeActuator{setB: make(chan float64, 1), err: errors.New("throttled")}
+	a := NewActuator(inner, Policy{MaxStepUp: 1})
+
+	// Without the workers running the policy can't be applied.
+	a.SetB() <- 6.2
+	select {
+	case b := <-inner.setB:
+		if b != 6.2 {
+			t.Errorf("expected beta to be passed through, got %v", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for beta")
+	}
+}
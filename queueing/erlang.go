@@ -0,0 +1,66 @@
+// Package queueing has formulas for M/M/c queues, i.e. Poisson arrivals at a
+// rate lambda, served by c workers taking exponentially distributed times
+// with a rate mu each, which the controller uses to reason about the wait
+// messages see for a number of workers.
+package queueing
+
+import "math"
+
+// Probability that an arrival has to wait, given c workers and an offered
+// load a = lambda / mu. One if the queue is unstable, with a >= c.
+func ErlangC(c int, a float64) float64 {
+	if a <= 0 {
+		return 0
+	}
+	if c <= 0 || a >= float64(c) {
+		return 1
+	}
+
+	// Erlang B by recursion, which doesn't overflow for large c.
+	b := 1.0
+	for k := 1; k <= c; k++ {
+		b = a * b / (float64(k) + a*b)
+	}
+	return float64(c) * b / (float64(c) - a*(1-b))
+}
+
+// Probability that an arrival waits longer than t, in the units of the rates.
+func WaitExceeds(c int, lambda, mu, t float64) float64 {
+	if lambda <= 0 {
+		return 0
+	}
+	if mu <= 0 || lambda >= float64(c)*mu {
+		return 1
+	}
+	return ErlangC(c, lambda/mu) * math.Exp(-(float64(c)*mu-lambda)*t)
+}
+
+// Mean time an arrival waits before being served, in the units of the rates.
+// Infinite if the queue is unstable.
+func MeanWait(c int, lambda, mu float64) float64 {
+	if lambda <= 0 {
+		return 0
+	}
+	if mu <= 0 || lambda >= float64(c)*mu {
+		return math.Inf(1)
+	}
+	return ErlangC(c, lambda/mu) / (float64(c)*mu - lambda)
+}
+
+// Wait not exceeded by a fraction p of the arrivals, e.g. 0.95, in the units
+// of the rates. Infinite if the queue is unstable.
+func WaitPercentile(c int, lambda, mu, p float64) float64 {
+	if lambda <= 0 {
+		return 0
+	}
+	if mu <= 0 || lambda >= float64(c)*mu || p >= 1 {
+		return math.Inf(1)
+	}
+
+	// P(wait > t) = C * e^-(c mu - lambda) t, zero if C is within 1 - p.
+	waiting := ErlangC(c, lambda/mu)
+	if waiting <= 1-p {
+		return 0
+	}
+	return math.Log(waiting/(1-p)) / (float64(c)*mu - lambda)
+}
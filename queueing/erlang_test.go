@@ -0,0 +1,64 @@
+package queueing
+
+import (
+	"math"
+	"testing"
+)
+
+func TestErlangC(t *testing.T) {
+	for _, tc := range []struct {
+		c    int
+		a, p float64
+	}{
+		// Single server, the probability of waiting is the utilisation.
+		{1, 0.5, 0.5},
+		{2, 1, 1.0 / 3},
+		{10, 8, 0.409},
+		{100, 90, 0.217},
+		{3, 3, 1},
+		{3, 0, 0},
+	} {
+		if p := ErlangC(tc.c, tc.a); math.Abs(p-tc.p) > 0.01 {
+			t.Errorf("ErlangC(%d, %v): expected %v, got %v", tc.c, tc.a, tc.p, p)
+		}
+	}
+
+	// Large systems don't overflow
+	if p := ErlangC(10000, 9900); math.IsNaN(p) || p <= 0 || p >= 1 {
+		t.Errorf("unexpected probability %v", p)
+	}
+}
+
+func TestWait(t *testing.T) {
+	// M/M/1 with a utilisation of 0.5: W = rho / (mu - lambda)
+	if w := MeanWait(1, 1, 2); math.Abs(w-0.5) > 1e-9 {
+		t.Errorf("expected a mean wait of 0.5, got %v", w)
+	}
+	if p := WaitExceeds(1, 1, 2, 1); math.Abs(p-0.5*math.Exp(-1)) > 1e-9 {
+		t.Errorf("unexpected probability %v", p)
+	}
+	if w := MeanWait(2, 4, 2); !math.IsInf(w, 1) {
+		t.Errorf("expected an infinite wait when unstable, got %v", w)
+	}
+	if p := WaitExceeds(2, 4, 2, 10); p != 1 {
+		t.Errorf("expected every arrival to wait when unstable, got %v", p)
+	}
+}
+
+func TestWaitPercentile(t *testing.T) {
+	// M/M/1 with a utilisation of 0.5: half the arrivals don't wait, and the
+	// rest wait for an exponential time with rate mu - lambda.
+	if w := WaitPercentile(1, 1, 2, 0.5); w != 0 {
+		t.Errorf("expected the median arrival not to wait, got %v", w)
+	}
+	w := WaitPercentile(1, 1, 2, 0.95)
+	if math.Abs(w-math.Log(10)) > 1e-9 {
+		t.Errorf("expected a p95 wait of ln 10, got %v", w)
+	}
+	if p := WaitExceeds(1, 1, 2, w); math.Abs(p-0.05) > 1e-9 {
+		t.Errorf("expected 5%% of arrivals to wait longer than the p95, got %v", p)
+	}
+	if w := WaitPercentile(2, 4, 2, 0.95); !math.IsInf(w, 1) {
+		t.Errorf("expected an infinite wait when unstable, got %v", w)
+	}
+}
@@ -0,0 +1,171 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Connection to the RabbitMQ management HTTP API.
+type Config struct {
+	URL                string // e.g. http://localhost:15672
+	Username, Password string
+
+	// Client to use, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Implements the control.Manager interface for a RabbitMQ queue, taking its
+// statistics from the management API.
+type Manager struct {
+	ctx          context.Context
+	config       *Config
+	vhost, queue string
+
+	// Stats, rates per second
+	dx, dy float64
+	xmy, q uint
+
+	// Stats have errored, returned when sampled
+	err error
+
+	// Guards stats and err, which are updated in the background
+	sync.Mutex
+
+	control control.Actuator
+}
+
+// Timeout for every request to the management API.
+const requestTimeout = 30 * time.Second
+
+// Stats are updated in the background until ctx is done.
+func NewManager(ctx context.Context, config *Config, vhost, queue string, updatePeriod time.Duration, control control.Actuator) *Manager {
+	m := &Manager{
+		ctx:     ctx,
+		config:  config,
+		vhost:   vhost,
+		queue:   queue,
+		control: control,
+	}
+
+	m.setErr(m.updateStats())
+	go m.run(updatePeriod)
+
+	return m
+}
+
+func (m *Manager) run(updatePeriod time.Duration) {
+	t := time.NewTicker(updatePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.setErr(m.ctx.Err())
+			return
+		case <-t.C:
+			m.setErr(m.updateStats())
+		}
+	}
+}
+
+func (m *Manager) setErr(err error) {
+	m.Lock()
+	m.err = err
+	m.Unlock()
+}
+
+func (m *Manager) SetB() chan float64 {
+	return m.control.SetB()
+}
+
+func (m *Manager) MuP() (float64, bool) {
+	return 0, false
+}
+
+// Queue as returned by GET /api/queues/{vhost}/{name}
+type queueInfo struct {
+	MessagesReady          uint `json:"messages_ready"`
+	MessagesUnacknowledged uint `json:"messages_unacknowledged"`
+	MessageStats           struct {
+		PublishDetails rate `json:"publish_details"`
+		AckDetails     rate `json:"ack_details"`
+	} `json:"message_stats"`
+}
+
+type rate struct {
+	Rate float64 `json:"rate"`
+}
+
+func (m *Manager) updateStats() error {
+	ctx, cancel := context.WithTimeout(m.ctx, requestTimeout)
+	defer cancel()
+
+	u := fmt.Sprintf("%s/api/queues/%s/%s", m.config.URL,
+		url.PathEscape(m.vhost), url.PathEscape(m.queue))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(m.config.Username, m.config.Password)
+
+	client := m.config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error querying RabbitMQ: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error querying RabbitMQ: queue %s in vhost %s: %s",
+			m.queue, m.vhost, resp.Status)
+	}
+
+	var qi queueInfo
+	if err := json.NewDecoder(resp.Body).Decode(&qi); err != nil {
+		return fmt.Errorf("Error parsing RabbitMQ response: %s", err)
+	}
+
+	m.Lock()
+	m.dx = qi.MessageStats.PublishDetails.Rate
+	m.dy = qi.MessageStats.AckDetails.Rate
+	m.q = qi.MessagesReady
+	m.xmy = qi.MessagesReady + qi.MessagesUnacknowledged
+	m.Unlock()
+
+	return nil
+}
+
+func (m *Manager) Sample(unit time.Duration) (control.Observation, error) {
+	beta, desired, pending, err := control.QueryWorkers(m.control)
+	if err != nil {
+		return control.Observation{}, fmt.Errorf("Error querying actuator for %s: %s", m.queue, err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return control.Observation{}, m.err
+	}
+
+	factor := float64(time.Second) / float64(unit)
+	return control.Observation{
+		DX:      m.dx / factor,
+		DY:      m.dy / factor,
+		XmY:     m.xmy,
+		Q:       m.q,
+		Beta:    beta,
+		Desired: desired,
+		Pending: pending,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type actuator struct {
+	beta uint
+}
+
+func (a *actuator) SetB() chan float64 {
+	return nil
+}
+
+func (a *actuator) Beta() (uint, error) {
+	return a.beta, nil
+}
+
+func TestManager(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "guest" || p != "guest" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// The default vhost must be escaped.
+		if r.URL.EscapedPath() != "/api/queues/%2F/jobs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{
+			"name": "jobs",
+			"messages": 42,
+			"messages_ready": 30,
+			"messages_unacknowledged": 12,
+			"message_stats": {
+				"publish": 1000,
+				"publish_details": {"rate": 5.0},
+				"ack": 900,
+				"ack_details": {"rate": 4.5}
+			}
+		}`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := &Config{URL: srv.URL, Username: "guest", Password: "guest"}
+	m := NewManager(ctx, config, "/", "jobs", time.Hour, &actuator{beta: 3})
+
+	obs, err := m.Sample(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.DX != 300 || obs.DY != 270 || obs.Q != 30 || obs.XmY != 42 || obs.Beta != 3 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	m = NewManager(ctx, config, "/", "missing", time.Hour, &actuator{})
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error for a missing queue")
+	}
+}
+
+func TestManagerStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"messages_ready": 1}`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx, &Config{URL: srv.URL}, "/", "jobs", time.Millisecond, &actuator{})
+	cancel()
+
+	// Once stopped, samples report why.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		_, err := m.Sample(time.Second)
+		if errors.Is(err, context.Canceled) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v once stopped, got %v", context.Canceled, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Connection to a Redis server.
+type Config struct {
+	Addr     string // host:port
+	Password string
+	DB       int
+
+	// Timeout for connecting and for every command, 30s if zero
+	Timeout time.Duration
+}
+
+// Error replied by the server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Minimal RESP2 client, just enough for querying queue statistics.
+type conn struct {
+	c       net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func dial(config *Config) (*conn, error) {
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	c, err := net.DialTimeout("tcp", config.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{c: c, r: bufio.NewReader(c), timeout: timeout}
+
+	if config.Password != "" {
+		if _, err := cn.do("AUTH", config.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if config.DB != 0 {
+		if _, err := cn.do("SELECT", strconv.Itoa(config.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// Send a command and read its reply, which is one of int64, string, nil or
+// []any. Errors replied by the server are returned as Error.
+func (c *conn) do(args ...string) (any, error) {
+	if err := c.c.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(c.c)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (c *conn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("Malformed Redis reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+
+	case '-':
+		return Error(line), nil
+
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		a := make([]any, n)
+		for i := range a {
+			if a[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+
+	return nil, fmt.Errorf("Unknown Redis reply type %q", kind)
+}
+
+// Reply as an integer, accepting integer strings as GET returns them. Nil is
+// taken as zero, as for missing keys.
+func toInt(reply any) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("Expected an integer reply, got %T", reply)
+}
+
+// Reply with alternating keys and values as a map, as XINFO returns them.
+func toMap(reply any) (map[string]any, error) {
+	a, ok := reply.([]any)
+	if !ok || len(a)%2 != 0 {
+		return nil, fmt.Errorf("Expected a key-value reply, got %T", reply)
+	}
+	m := make(map[string]any, len(a)/2)
+	for i := 0; i < len(a); i += 2 {
+		k, ok := a[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("Expected a string key, got %T", a[i])
+		}
+		m[k] = a[i+1]
+	}
+	return m, nil
+}
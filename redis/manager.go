@@ -0,0 +1,324 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Redis list used as a queue. Producers push to Key and workers pop from it,
+// optionally moving messages to ProcessingKey while they work on them (as with
+// LMOVE). Since lists keep no history, producers and workers are expected to
+// INCR the EnqueuedKey and CompletedKey counters, from which the rates are
+// computed.
+type List struct {
+	Key           string
+	ProcessingKey string // Optional
+
+	EnqueuedKey, CompletedKey string
+}
+
+// Implements the control.Manager interface for a Redis list or a Redis stream
+// read by a consumer group.
+type Manager struct {
+	ctx    context.Context
+	config *Config
+	conn   *conn
+	name   string // For errors
+
+	// Samples q and w and the cumulative arrivals and completions, and
+	// whether the completions could be counted
+	sample func(c *conn) (q, w uint, x, y int64, counted bool, err error)
+
+	// Last counters, to compute the rates from
+	x, y      int64
+	timestamp time.Time
+	sampled   bool
+	counted   bool
+
+	// Stats, rates per second
+	dx, dy float64
+	xmy, q uint
+
+	// Stats have errored, returned when sampled
+	err error
+
+	// Guards stats and err, which are updated in the background
+	sync.Mutex
+
+	control control.Actuator
+}
+
+// Stats are updated in the background until ctx is done, when the connection
+// is closed.
+func NewListManager(ctx context.Context, config *Config, list List, updatePeriod time.Duration, control control.Actuator) *Manager {
+	m := &Manager{
+		ctx:     ctx,
+		config:  config,
+		name:    list.Key,
+		control: control,
+		sample: func(c *conn) (q, w uint, x, y int64, counted bool, err error) {
+			if q, err = llen(c, list.Key); err != nil {
+				return
+			}
+			if list.ProcessingKey != "" {
+				if w, err = llen(c, list.ProcessingKey); err != nil {
+					return
+				}
+			}
+			if x, err = get(c, list.EnqueuedKey); err != nil {
+				return
+			}
+			y, err = get(c, list.CompletedKey)
+			return q, w, x, y, true, err
+		},
+	}
+
+	m.start(updatePeriod)
+	return m
+}
+
+// Manager for a stream read by a consumer group. Q is the number of entries
+// not yet delivered to the group and W the entries pending acknowledgement.
+// The rates are computed from the entries added to the stream and acknowledged
+// by the group, which requires Redis 7. Q is counted from the entries added
+// too, rather than from the stream's length, so that it holds for streams
+// trimmed with MAXLEN, XTRIM or XDEL.
+//
+// Redis doesn't always know how many entries a group has read, as when the
+// group was created or set to an arbitrary ID, or entries were deleted after
+// its last delivered one. Unless the group has caught up, Q is then bounded
+// by the entries left in the stream, and the completion rate is held until
+// the count is known again.
+//
+// Stats are updated in the background until ctx is done, when the connection
+// is closed.
+func NewStreamManager(ctx context.Context, config *Config, stream, group string, updatePeriod time.Duration, control control.Actuator) *Manager {
+	m := &Manager{
+		ctx:     ctx,
+		config:  config,
+		name:    stream,
+		control: control,
+		sample: func(c *conn) (q, w uint, x, y int64, counted bool, err error) {
+			g, err := groupInfo(c, stream, group)
+			if err != nil {
+				return
+			}
+			read, known := g["entries-read"]
+			if !known {
+				err = fmt.Errorf("Group %s hasn't got entries-read, Redis 7 is required", group)
+				return
+			}
+
+			reply, err := c.do("XPENDING", stream, group)
+			if err != nil {
+				return
+			}
+			summary, ok := reply.([]any)
+			if !ok || len(summary) == 0 {
+				err = fmt.Errorf("Unexpected XPENDING reply %v", reply)
+				return
+			}
+			pending, err := toInt(summary[0])
+			if err != nil {
+				return
+			}
+
+			reply, err = c.do("XINFO", "STREAM", stream)
+			if err != nil {
+				return
+			}
+			info, err := toMap(reply)
+			if err != nil {
+				return
+			}
+			if x, err = toInt(info["entries-added"]); err != nil {
+				return
+			}
+			length, err := toInt(info["length"])
+			if err != nil {
+				return
+			}
+			w = uint(pending)
+
+			if read == nil {
+				if g["last-delivered-id"] != info["last-generated-id"] {
+					// Entries pending are usually still in the stream.
+					if length > pending {
+						q = uint(length - pending)
+					}
+					return
+				}
+				// Everything added has been delivered.
+				read = x
+			}
+
+			n, err := toInt(read)
+			if err != nil {
+				return
+			}
+			// Entries trimmed before being read won't ever be delivered.
+			if undelivered := x - n; undelivered > 0 {
+				if undelivered > length {
+					undelivered = length
+				}
+				q = uint(undelivered)
+			}
+			y, counted = n-pending, true
+			return
+		},
+	}
+
+	m.start(updatePeriod)
+	return m
+}
+
+// Info on a consumer group, as XINFO GROUPS returns it.
+func groupInfo(c *conn, stream, group string) (map[string]any, error) {
+	reply, err := c.do("XINFO", "GROUPS", stream)
+	if err != nil {
+		return nil, err
+	}
+	groups, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected XINFO GROUPS reply %v", reply)
+	}
+	for _, g := range groups {
+		info, err := toMap(g)
+		if err != nil {
+			return nil, err
+		}
+		if info["name"] == group {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("Group %s not found in stream %s", group, stream)
+}
+
+func llen(c *conn, key string) (uint, error) {
+	reply, err := c.do("LLEN", key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := toInt(reply)
+	return uint(n), err
+}
+
+func get(c *conn, key string) (int64, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return 0, err
+	}
+	return toInt(reply)
+}
+
+func (m *Manager) start(updatePeriod time.Duration) {
+	m.setErr(m.updateStats())
+	go m.run(updatePeriod)
+}
+
+func (m *Manager) run(updatePeriod time.Duration) {
+	t := time.NewTicker(updatePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			if m.conn != nil {
+				m.conn.Close()
+				m.conn = nil
+			}
+			m.setErr(m.ctx.Err())
+			return
+		case <-t.C:
+			m.setErr(m.updateStats())
+		}
+	}
+}
+
+func (m *Manager) setErr(err error) {
+	m.Lock()
+	m.err = err
+	m.Unlock()
+}
+
+func (m *Manager) updateStats() error {
+	if m.conn == nil {
+		c, err := dial(m.config)
+		if err != nil {
+			return fmt.Errorf("Error connecting to Redis: %s", err)
+		}
+		m.conn = c
+	}
+
+	now := time.Now()
+	q, w, x, y, counted, err := m.sample(m.conn)
+	if err != nil {
+		if _, ok := err.(Error); !ok {
+			// The connection might be broken, start afresh.
+			m.conn.Close()
+			m.conn = nil
+		}
+		return fmt.Errorf("Error querying Redis for %s: %s", m.name, err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.sampled {
+		elapsed := now.Sub(m.timestamp).Seconds()
+		m.dx = rate(m.x, x, elapsed)
+		if m.counted && counted {
+			m.dy = rate(m.y, y, elapsed)
+		}
+	}
+	m.x, m.y, m.timestamp, m.sampled, m.counted = x, y, now, true, counted
+	m.q, m.xmy = q, q+w
+
+	return nil
+}
+
+// Rate per second of a counter going from prev to cur. Counters that have
+// been reset are taken as not having moved.
+func rate(prev, cur int64, elapsed float64) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / elapsed
+}
+
+func (m *Manager) SetB() chan float64 {
+	return m.control.SetB()
+}
+
+func (m *Manager) MuP() (float64, bool) {
+	return 0, false
+}
+
+func (m *Manager) Sample(unit time.Duration) (control.Observation, error) {
+	beta, desired, pending, err := control.QueryWorkers(m.control)
+	if err != nil {
+		return control.Observation{}, fmt.Errorf("Error querying actuator for %s: %s", m.name, err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return control.Observation{}, m.err
+	}
+
+	factor := float64(time.Second) / float64(unit)
+	return control.Observation{
+		DX:      m.dx / factor,
+		DY:      m.dy / factor,
+		XmY:     m.xmy,
+		Q:       m.q,
+		Beta:    beta,
+		Desired: desired,
+		Pending: pending,
+	}, nil
+}
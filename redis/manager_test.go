@@ -0,0 +1,331 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Redis server replying to commands from a table, keyed by the command
+// and its arguments joined by spaces.
+type fakeRedis struct {
+	l       net.Listener
+	replies map[string]any
+	open    int // Connections open
+	sync.Mutex
+}
+
+func newFakeRedis(t *testing.T, replies map[string]any) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{l: l, replies: replies}
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) set(cmd string, reply any) {
+	f.Lock()
+	f.replies[cmd] = reply
+	f.Unlock()
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		c, err := f.l.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(c)
+	}
+}
+
+func (f *fakeRedis) conns() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.open
+}
+
+func (f *fakeRedis) handle(c net.Conn) {
+	f.Lock()
+	f.open++
+	f.Unlock()
+	defer func() {
+		f.Lock()
+		f.open--
+		f.Unlock()
+	}()
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var l int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &l); err != nil {
+				return
+			}
+			b := make([]byte, l+2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			args[i] = string(b[:l])
+		}
+
+		f.Lock()
+		reply, ok := f.replies[strings.Join(args, " ")]
+		f.Unlock()
+		if !ok {
+			reply = Error("ERR unknown command " + args[0])
+		}
+		writeReply(w, reply)
+		w.Flush()
+	}
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case nil:
+		fmt.Fprintf(w, "$-1\r\n")
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	}
+}
+
+type actuator struct {
+	beta uint
+}
+
+func (a *actuator) SetB() chan float64 {
+	return nil
+}
+
+func (a *actuator) Beta() (uint, error) {
+	return a.beta, nil
+}
+
+// Update the stats of m as if elapsed had passed since the last update.
+func updateAfter(t *testing.T, m *Manager, elapsed time.Duration) {
+	m.Lock()
+	m.timestamp = m.timestamp.Add(-elapsed)
+	m.Unlock()
+	if err := m.updateStats(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListManager(t *testing.T) {
+	f := newFakeRedis(t, map[string]any{
+		"AUTH secret":       "OK",
+		"LLEN jobs":         25,
+		"LLEN jobs:working": 4,
+		"GET jobs:enqueued": "1000",
+		"GET jobs:done":     nil,
+	})
+	defer f.l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := &Config{Addr: f.l.Addr().String(), Password: "secret"}
+	list := List{
+		Key:           "jobs",
+		ProcessingKey: "jobs:working",
+		EnqueuedKey:   "jobs:enqueued",
+		CompletedKey:  "jobs:done",
+	}
+	m := NewListManager(ctx, config, list, time.Hour, &actuator{beta: 2})
+
+	f.set("GET jobs:enqueued", "1100")
+	f.set("GET jobs:done", "80")
+	updateAfter(t, m, 10*time.Second)
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(obs.DX-10) > 0.1 || math.Abs(obs.DY-8) > 0.1 {
+		t.Errorf("expected rates of 10 and 8 per second, got %v and %v", obs.DX, obs.DY)
+	}
+	if obs.Q != 25 || obs.XmY != 29 || obs.Beta != 2 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+}
+
+func TestStreamManager(t *testing.T) {
+	groups := func(read int) []any {
+		return []any{
+			[]any{"name", "other", "entries-read", 1},
+			[]any{"name", "workers", "pending", 5, "entries-read", read},
+		}
+	}
+	f := newFakeRedis(t, map[string]any{
+		"XINFO GROUPS events":     groups(70),
+		"XPENDING events workers": []any{5, "1-0", "9-0", []any{}},
+		"XINFO STREAM events":     []any{"length", 100, "entries-added", 100},
+		"XINFO GROUPS missing":    Error("ERR no such key"),
+	})
+	defer f.l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := &Config{Addr: f.l.Addr().String()}
+	m := NewStreamManager(ctx, config, "events", "workers", time.Hour, &actuator{beta: 3})
+
+	f.set("XINFO GROUPS events", groups(90))
+	f.set("XINFO STREAM events", []any{"length", 120, "entries-added", 120})
+	updateAfter(t, m, 20*time.Second)
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 added and 20 acknowledged in 20 seconds, 30 not delivered.
+	if math.Abs(obs.DX-1) > 0.01 || math.Abs(obs.DY-1) > 0.01 {
+		t.Errorf("expected rates of 1 per second, got %v and %v", obs.DX, obs.DY)
+	}
+	if obs.Q != 30 || obs.XmY != 35 || obs.Beta != 3 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	// Trimmed down to the last 40 entries, 30 of them not delivered yet.
+	f.set("XINFO GROUPS events", groups(170))
+	f.set("XINFO STREAM events", []any{"length", 40, "entries-added", 200})
+	updateAfter(t, m, 20*time.Second)
+	if obs, err = m.Sample(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if obs.Q != 30 || obs.XmY != 35 {
+		t.Errorf("expected 30 entries queued in a trimmed stream, got %+v", obs)
+	}
+
+	// Trimmed down to 10 entries, with older ones never delivered.
+	f.set("XINFO STREAM events", []any{"length", 10, "entries-added", 200})
+	updateAfter(t, m, 20*time.Second)
+	if obs, err = m.Sample(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if obs.Q != 10 {
+		t.Errorf("expected only the 10 entries left queued, got %+v", obs)
+	}
+
+	m = NewStreamManager(ctx, config, "missing", "workers", time.Hour, &actuator{})
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error for a missing stream")
+	}
+}
+
+func TestStreamManagerWithoutEntriesRead(t *testing.T) {
+	// As after XGROUP SETID without ENTRIESREAD.
+	groups := func(read any, delivered string) []any {
+		return []any{
+			[]any{"name", "workers", "pending", 5, "last-delivered-id", delivered, "entries-read", read},
+		}
+	}
+	f := newFakeRedis(t, map[string]any{
+		"XINFO GROUPS events":     groups(70, "70-0"),
+		"XPENDING events workers": []any{5, "1-0", "9-0", []any{}},
+		"XINFO STREAM events":     []any{"length", 100, "entries-added", 100, "last-generated-id", "100-0"},
+		"XINFO GROUPS old":        []any{[]any{"name", "workers", "pending", 5}},
+	})
+	defer f.l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := &Config{Addr: f.l.Addr().String()}
+	m := NewStreamManager(ctx, config, "events", "workers", time.Hour, &actuator{beta: 3})
+
+	// Q is bounded by the entries in the stream, and completions held.
+	f.set("XINFO GROUPS events", groups(nil, "90-0"))
+	f.set("XINFO STREAM events", []any{"length", 120, "entries-added", 120, "last-generated-id", "120-0"})
+	updateAfter(t, m, 20*time.Second)
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.Q != 115 || obs.XmY != 120 || math.Abs(obs.DX-1) > 0.01 || obs.DY != 0 {
+		t.Errorf("unexpected observation %+v without entries-read", obs)
+	}
+
+	// Caught up, so every entry added has been read.
+	f.set("XINFO GROUPS events", groups(nil, "130-0"))
+	f.set("XINFO STREAM events", []any{"length", 130, "entries-added", 130, "last-generated-id", "130-0"})
+	updateAfter(t, m, 10*time.Second)
+	if obs, err = m.Sample(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if obs.Q != 0 || obs.XmY != 5 || obs.DY != 0 {
+		t.Errorf("unexpected observation %+v once caught up", obs)
+	}
+
+	// Counted again, the completion rate resumes.
+	f.set("XINFO GROUPS events", groups(150, "150-0"))
+	f.set("XINFO STREAM events", []any{"length", 150, "entries-added", 150, "last-generated-id", "150-0"})
+	updateAfter(t, m, 20*time.Second)
+	if obs, err = m.Sample(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(obs.DY-1) > 0.01 {
+		t.Errorf("expected a completion rate of 1 per second, got %v", obs.DY)
+	}
+
+	m = NewStreamManager(ctx, config, "old", "workers", time.Hour, &actuator{})
+	if _, err := m.Sample(time.Second); err == nil || !strings.Contains(err.Error(), "Redis 7") {
+		t.Errorf("expected Redis 7 to be required, got %v", err)
+	}
+}
+
+func TestManagerStops(t *testing.T) {
+	f := newFakeRedis(t, map[string]any{
+		"LLEN jobs":         1,
+		"GET jobs:enqueued": "1",
+		"GET jobs:done":     "0",
+	})
+	defer f.l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	config := &Config{Addr: f.l.Addr().String()}
+	list := List{Key: "jobs", EnqueuedKey: "jobs:enqueued", CompletedKey: "jobs:done"}
+	m := NewListManager(ctx, config, list, time.Millisecond, &actuator{})
+	if _, err := m.Sample(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	// Once stopped, samples report why and the connection is closed.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		_, err := m.Sample(time.Second)
+		if errors.Is(err, context.Canceled) && f.conns() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v and no connections once stopped, got %v and %d", context.Canceled, err, f.conns())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
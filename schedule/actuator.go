@@ -0,0 +1,94 @@
+package schedule
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Wraps an actuator, applying the limits of a schedule to the beta set on it,
+// and raising it to what a forecaster expects to be needed, if any. Limits
+// on the wrapped actuator still apply, so it's best to leave them to the
+// schedule.
+type Actuator struct {
+	actuator control.Actuator
+	schedule *Schedule
+	setB     chan float64
+	clock    clock.Clock
+
+	forecaster *Forecaster
+	lead       time.Duration
+
+	sync.Mutex
+}
+
+func NewActuator(actuator control.Actuator, schedule *Schedule) *Actuator {
+	a := &Actuator{
+		actuator: actuator,
+		schedule: schedule,
+		setB:     make(chan float64),
+		clock:    clock.Real{},
+	}
+
+	go a.run()
+
+	return a
+}
+
+func (a *Actuator) run() {
+	for b := range a.setB {
+		a.actuator.SetB() <- a.beta(b)
+	}
+}
+
+// Beta to set instead of b, at the current time.
+func (a *Actuator) beta(b float64) float64 {
+	a.Lock()
+	now, f, lead := a.clock.Now(), a.forecaster, a.lead
+	a.Unlock()
+
+	if f != nil {
+		b = math.Max(b, f.Floor(now, lead))
+	}
+
+	min, max := a.schedule.Limits(now)
+	if b < float64(min) {
+		b = float64(min)
+	} else if max > 0 && b > float64(max) {
+		b = float64(max)
+	}
+	return b
+}
+
+// Raise beta to the workers f expects to be needed within lead, so that
+// they're running by the time the load arrives. Lead should cover the time
+// workers take to start.
+func (a *Actuator) SetForecaster(f *Forecaster, lead time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.forecaster, a.lead = f, lead
+}
+
+// Use clk instead of the wall clock.
+func (a *Actuator) SetClock(clk clock.Clock) {
+	a.Lock()
+	defer a.Unlock()
+	a.clock = clk
+}
+
+func (a *Actuator) SetB() chan float64 {
+	return a.setB
+}
+
+func (a *Actuator) Beta() (uint, error) {
+	return a.actuator.Beta()
+}
+
+// Implements control.PendingActuator, with the workers pending on the wrapped
+// actuator if it reports them.
+func (a *Actuator) Workers() (running, desired, pending uint, err error) {
+	return control.QueryWorkers(a.actuator)
+}
@@ -0,0 +1,193 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron expression with the usual five fields, minute, hour, day of month,
+// month and day of week, evaluated in a time zone. Fields take numbers, *,
+// ranges (a-b), steps (*/n, a-b/n) and lists of these; months and days of the
+// week can also be given by their three-letter English names. Sunday is
+// either 0 or 7.
+//
+// As in cron, if both the day of month and the day of week are restricted, a
+// day matches if either of them does.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // Bit sets
+
+	// Whether day of month and day of week are *
+	domStar, dowStar bool
+
+	location *time.Location
+}
+
+type field struct {
+	min, max uint
+	names    []string // From min, if any
+}
+
+var (
+	minutes = field{0, 59, nil}
+	hours   = field{0, 23, nil}
+	doms    = field{1, 31, nil}
+	months  = field{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dows    = field{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Parse a cron expression, evaluated in loc; UTC if nil.
+func ParseCron(expr string, loc *time.Location) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields in cron expression %q, got %d", expr, len(fields))
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	c := &Cron{
+		domStar:  fields[2] == "*",
+		dowStar:  fields[4] == "*",
+		location: loc,
+	}
+	var err error
+	for i, f := range []struct {
+		set *uint64
+		field
+	}{
+		{&c.minute, minutes},
+		{&c.hour, hours},
+		{&c.dom, doms},
+		{&c.month, months},
+		{&c.dow, dows},
+	} {
+		if *f.set, err = f.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("Error parsing cron expression %q: %s", expr, err)
+		}
+	}
+
+	// Sunday is 0 as in time.Weekday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// Like ParseCron, but panics on errors. For expressions known to be valid.
+func MustParseCron(expr string, loc *time.Location) *Cron {
+	c, err := ParseCron(expr, loc)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (f field) parse(s string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 0)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("Invalid step in %q", part)
+			}
+			rng, step = part[:i], uint(n)
+		}
+
+		var lo, hi uint
+		if rng == "*" {
+			lo, hi = f.min, f.max
+		} else if i := strings.Index(rng, "-"); i >= 0 {
+			var err error
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("Invalid range %q", rng)
+			}
+		} else {
+			v, err := f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			if step > 1 {
+				// a/n is a through the maximum
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(s string) (uint, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + uint(i), nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 0)
+	if err != nil || uint(v) < f.min || uint(v) > f.max {
+		return 0, fmt.Errorf("Invalid value %q, expected %d-%d", s, f.min, f.max)
+	}
+	return uint(v), nil
+}
+
+// Whether the minute of t matches.
+func (c *Cron) Match(t time.Time) bool {
+	t = t.In(c.location)
+	return c.minute&(1<<uint(t.Minute())) != 0 &&
+		c.hour&(1<<uint(t.Hour())) != 0 &&
+		c.month&(1<<uint(t.Month())) != 0 &&
+		c.matchDay(t)
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Don't look further than this for the next match, for expressions that never
+// match, e.g. on February 30th.
+const horizon = 5 * 366 * 24 * time.Hour
+
+// First minute matching after t, or the zero time if there's none in the next
+// five years.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.In(c.location)
+	end := t.Add(horizon)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Before(end) {
+		y, mo, d := t.Date()
+		switch {
+		case c.month&(1<<uint(mo)) == 0:
+			t = time.Date(y, mo+1, 1, 0, 0, 0, 0, c.location)
+		case !c.matchDay(t):
+			t = time.Date(y, mo, d+1, 0, 0, 0, 0, c.location)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			// By adding an hour rather than through time.Date, so that
+			// the repeated hour isn't skipped when clocks go back.
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,71 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"* * * * *",
+		"*/15 8-18 * * mon-fri",
+		"0,30 9 1 jan,jul 0",
+		"5/20 * 1-7 * 7",
+	} {
+		if _, err := ParseCron(expr, nil); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * fun",
+	} {
+		if _, err := ParseCron(expr, nil); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, tc := range []struct {
+		expr     string
+		loc      *time.Location
+		from, to string
+	}{
+		{"*/15 * * * *", time.UTC, "2023-11-20T10:07:30Z", "2023-11-20T10:15:00Z"},
+		{"*/15 * * * *", time.UTC, "2023-11-20T10:15:00Z", "2023-11-20T10:30:00Z"},
+		{"0 8 * * mon-fri", time.UTC, "2023-11-24T09:00:00Z", "2023-11-27T08:00:00Z"},
+		{"0 8 * * mon-fri", madrid, "2023-11-24T09:00:00Z", "2023-11-27T07:00:00Z"},
+		{"0 8 * * mon-fri", madrid, "2023-07-24T09:00:00Z", "2023-07-25T06:00:00Z"},
+		// Either the day of the month or of the week
+		{"0 0 1 * sun", time.UTC, "2023-11-20T00:00:00Z", "2023-11-26T00:00:00Z"},
+		{"0 0 1 * sun", time.UTC, "2023-11-27T00:00:00Z", "2023-12-01T00:00:00Z"},
+		{"30 12 29 feb *", time.UTC, "2023-03-01T00:00:00Z", "2024-02-29T12:30:00Z"},
+		// Across the end of daylight saving time
+		{"30 * * * *", madrid, "2023-10-29T00:40:00Z", "2023-10-29T01:30:00Z"},
+	} {
+		from, _ := time.Parse(time.RFC3339, tc.from)
+		to, _ := time.Parse(time.RFC3339, tc.to)
+		c := MustParseCron(tc.expr, tc.loc)
+		if next := c.Next(from); !next.Equal(to) {
+			t.Errorf("%s from %s: expected %s, got %s", tc.expr, tc.from, to, next.UTC())
+		}
+	}
+
+	if next := MustParseCron("0 0 30 feb *", nil).Next(time.Now()); !next.IsZero() {
+		t.Errorf("expected no match, got %s", next)
+	}
+}
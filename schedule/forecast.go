@@ -0,0 +1,166 @@
+package schedule
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+	"github.com/Lowercases/queue-scaling/ema"
+)
+
+// Learns the daily or weekly profile of the arrival rate from the decisions of
+// a controller, to pre-scale ahead of the load it predicts. Implements
+// control.Sink:
+//
+//	f := schedule.NewForecaster(schedule.Weekly, 15*time.Minute, loc)
+//	c.AddSink(f)
+//	a.SetForecaster(f, 30*time.Minute)
+//
+// The season is split in slots; every slot keeps a moving average of the mean
+// arrival rate seen in it over the last seasons.
+type Forecaster struct {
+	season, slot time.Duration
+	location     *time.Location
+
+	// Learnt profile, a moving average per slot, and how many seasons went
+	// into every one
+	profile []*ema.EMA
+	seen    []int
+
+	// Slot being observed, and its running mean
+	current    int
+	started    bool
+	sum        float64
+	samples    int
+	lastSample time.Time
+
+	// Last throughput per worker estimated by the controller
+	r float64
+
+	sync.Mutex
+}
+
+const (
+	Daily  = 24 * time.Hour
+	Weekly = 7 * Daily
+)
+
+// Forecaster for a Daily or Weekly season, split in slots, following the wall
+// clock in loc (UTC if nil) so that the profile follows daylight saving time.
+func NewForecaster(season, slot time.Duration, loc *time.Location) *Forecaster {
+	if season != Daily && season != Weekly {
+		panic("season must be Daily or Weekly")
+	}
+	if slot <= 0 || season%slot != 0 {
+		panic("slot must divide the season")
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	n := int(season / slot)
+	f := &Forecaster{
+		season:   season,
+		slot:     slot,
+		location: loc,
+		profile:  make([]*ema.EMA, n),
+		seen:     make([]int, n),
+	}
+	f.SetSeasons(4)
+	return f
+}
+
+// Number of seasons the profile remembers, as the size of the moving
+// averages; 4 by default. Resets what was learnt.
+func (f *Forecaster) SetSeasons(n int) {
+	f.Lock()
+	defer f.Unlock()
+
+	for i := range f.profile {
+		f.profile[i] = ema.NewEMA(n)
+		f.seen[i] = 0
+	}
+}
+
+// Slot of the season t falls in.
+func (f *Forecaster) index(t time.Time) int {
+	t = t.In(f.location)
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	if f.season == Weekly {
+		offset += time.Duration(t.Weekday()) * Daily
+	}
+	return int(offset / f.slot)
+}
+
+// Implements control.Sink
+func (f *Forecaster) Record(d control.Decision) {
+	if d.Branch == control.Failed {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if d.R > 0 {
+		f.r = d.R
+	}
+
+	i := f.index(d.Time)
+	if f.started && (i != f.current || d.Time.Sub(f.lastSample) >= f.slot) {
+		f.fold()
+	}
+	f.current, f.started, f.lastSample = i, true, d.Time
+	f.sum += d.DX
+	f.samples++
+}
+
+// Add the mean of the slot being observed to the profile.
+func (f *Forecaster) fold() {
+	if f.samples > 0 {
+		f.profile[f.current].Add(f.sum / float64(f.samples))
+		f.seen[f.current]++
+	}
+	f.sum, f.samples = 0, 0
+}
+
+// Arrival rate expected at t, per unit, and whether the profile has been
+// learnt for it.
+func (f *Forecaster) DX(t time.Time) (float64, bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	i := f.index(t)
+	if f.seen[i] == 0 {
+		return 0, false
+	}
+	return f.profile[i].Value(), true
+}
+
+// Workers needed for the highest arrival rate expected between t and t +
+// lead, at the last throughput per worker estimated by the controller. Zero
+// if unknown.
+func (f *Forecaster) Floor(t time.Time, lead time.Duration) float64 {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.r <= 0 {
+		return 0
+	}
+
+	var dx float64
+	for at := t; ; at = at.Add(f.slot) {
+		if at.After(t.Add(lead)) {
+			at = t.Add(lead)
+		}
+		if i := f.index(at); f.seen[i] > 0 {
+			dx = math.Max(dx, f.profile[i].Value())
+		}
+		if !at.Before(t.Add(lead)) {
+			break
+		}
+	}
+	return dx / f.r
+}
@@ -0,0 +1,38 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+func TestForecaster(t *testing.T) {
+	f := NewForecaster(Daily, time.Hour, nil)
+	start := time.Date(2023, 11, 20, 0, 0, 0, 0, time.UTC)
+
+	// Two days of a morning ramp, decided every minute
+	for at := start; at.Before(start.Add(2 * Daily)); at = at.Add(time.Minute) {
+		dx := 2.0
+		if h := at.Hour(); h >= 8 && h < 12 {
+			dx = 20
+		}
+		f.Record(control.Decision{Time: at, DX: dx, R: 2, Branch: control.Queued})
+		f.Record(control.Decision{Time: at, Branch: control.Failed})
+	}
+
+	now := start.Add(2*Daily + 7*time.Hour)
+	if dx, ok := f.DX(now); !ok || dx != 2 {
+		t.Errorf("expected a rate of 2 at 7:00, got %v, %v", dx, ok)
+	}
+	if b := f.Floor(now, 30*time.Minute); b != 1 {
+		t.Errorf("expected a floor of 1 worker, got %v", b)
+	}
+	if b := f.Floor(now, time.Hour); b != 10 {
+		t.Errorf("expected a floor of 10 workers ahead of the ramp, got %v", b)
+	}
+
+	if _, ok := NewForecaster(Weekly, time.Hour, nil).DX(now); ok {
+		t.Error("expected no forecast without history")
+	}
+}
@@ -0,0 +1,80 @@
+// Package schedule raises the limits of a plant's workers on a schedule, e.g.
+// ahead of a daily ramp that the controller would otherwise follow with a lag,
+// and optionally from a forecast of the load learned from the controller's
+// decisions.
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// A window during which the limits are raised. The window opens every time
+// Start matches and lasts for Duration.
+type Rule struct {
+	Start    *Cron
+	Duration time.Duration
+
+	// Floors for the limits while the window is open. Zero leaves the limit
+	// as it is.
+	Min, Max int64
+}
+
+// Parse a rule from a cron expression in loc, e.g. "0 8 * * mon-fri" with a
+// duration of 10 hours for office hours.
+func NewRule(start string, loc *time.Location, duration time.Duration, min, max int64) (Rule, error) {
+	c, err := ParseCron(start, loc)
+	if err != nil {
+		return Rule{}, err
+	}
+	if duration <= 0 {
+		return Rule{}, fmt.Errorf("Duration must be positive, got %s", duration)
+	}
+	if max > 0 && min > max {
+		return Rule{}, fmt.Errorf("min > max")
+	}
+	return Rule{Start: c, Duration: duration, Min: min, Max: max}, nil
+}
+
+// Whether the window is open at t.
+func (r Rule) Active(t time.Time) bool {
+	next := r.Start.Next(t.Add(-r.Duration))
+	return !next.IsZero() && !next.After(t)
+}
+
+// Limits of the workers, static ones raised by the rules whose windows are
+// open.
+type Schedule struct {
+	min, max int64
+	rules    []Rule
+}
+
+// Schedule with static limits min and max, zero for none, as in
+// sqs.ECSManager.SetLimits.
+func NewSchedule(min, max int64, rules ...Rule) *Schedule {
+	if max > 0 && min > max {
+		panic("min > max")
+	}
+	return &Schedule{min: min, max: max, rules: rules}
+}
+
+// Limits at t. Rules only ever raise them: the highest floors of the open
+// windows win.
+func (s *Schedule) Limits(t time.Time) (min, max int64) {
+	min, max = s.min, s.max
+	for _, r := range s.rules {
+		if !r.Active(t) {
+			continue
+		}
+		if r.Min > min {
+			min = r.Min
+		}
+		if max > 0 && r.Max > max {
+			max = r.Max
+		}
+	}
+	if max > 0 && min > max {
+		max = min
+	}
+	return min, max
+}
@@ -0,0 +1,93 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+)
+
+func mustRule(t *testing.T, start string, d time.Duration, min, max int64) Rule {
+	r, err := NewRule(start, nil, d, min, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestScheduleLimits(t *testing.T) {
+	s := NewSchedule(1, 10,
+		// Office hours, and a batch at midnight overlapping the weekend
+		mustRule(t, "0 8 * * mon-fri", 10*time.Hour, 5, 20),
+		mustRule(t, "0 23 * * *", 2*time.Hour, 8, 0),
+	)
+
+	for _, tc := range []struct {
+		at       string
+		min, max int64
+	}{
+		{"2023-11-20T07:59:00Z", 1, 10},
+		{"2023-11-20T08:00:00Z", 5, 20},
+		{"2023-11-20T17:59:00Z", 5, 20},
+		{"2023-11-20T18:00:00Z", 1, 10},
+		{"2023-11-25T10:00:00Z", 1, 10},
+		{"2023-11-25T00:30:00Z", 8, 10},
+		{"2023-11-25T01:00:00Z", 1, 10},
+	} {
+		at, _ := time.Parse(time.RFC3339, tc.at)
+		if min, max := s.Limits(at); min != tc.min || max != tc.max {
+			t.Errorf("%s: expected limits %d-%d, got %d-%d", tc.at, tc.min, tc.max, min, max)
+		}
+	}
+
+	if _, err := NewRule("0 8 * * *", nil, 0, 1, 2); err == nil {
+		t.Error("expected an error for an empty window")
+	}
+}
+
+type fakeActuator struct {
+	setB chan float64
+}
+
+func (a *fakeActuator) SetB() chan float64 {
+	return a.setB
+}
+
+func (a *fakeActuator) Beta() (uint, error) {
+	return 3, nil
+}
+
+func TestActuator(t *testing.T) {
+	start := time.Date(2023, 11, 20, 7, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	inner := &fakeActuator{setB: make(chan float64)}
+
+	a := NewActuator(inner, NewSchedule(0, 10, mustRule(t, "0 8 * * *", time.Hour, 6, 0)))
+	a.SetClock(clk)
+
+	set := func(b float64) float64 {
+		a.SetB() <- b
+		select {
+		case b := <-inner.setB:
+			return b
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for beta")
+		}
+		return 0
+	}
+
+	if b := set(2); b != 2 {
+		t.Errorf("expected beta to be left alone, got %v", b)
+	}
+	if b := set(12); b != 10 {
+		t.Errorf("expected beta capped to 10, got %v", b)
+	}
+	clk.Advance(time.Hour)
+	if b := set(2); b != 6 {
+		t.Errorf("expected beta raised to 6, got %v", b)
+	}
+
+	if beta, _ := a.Beta(); beta != 3 {
+		t.Errorf("expected beta from the wrapped actuator, got %d", beta)
+	}
+}
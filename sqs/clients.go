@@ -0,0 +1,32 @@
+package sqs
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// AWS clients used by the managers. They can be shared between managers, and
+// replaced by fakes for testing.
+type Clients struct {
+	SQS        sqsiface.SQSAPI
+	CloudWatch cloudwatchiface.CloudWatchAPI
+	ECS        ecsiface.ECSAPI
+}
+
+func NewClients(sess *session.Session) *Clients {
+	return &Clients{
+		SQS:        awssqs.New(sess),
+		CloudWatch: cloudwatch.New(sess),
+		ECS:        ecs.New(sess),
+	}
+}
+
+// Clients for the default session, as configured by the environment.
+func defaultClients() *Clients {
+	return NewClients(session.Must(session.NewSession()))
+}
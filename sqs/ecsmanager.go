@@ -6,25 +6,35 @@ import (
 	"math"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
 )
 
 type ECSManager struct {
 	setB             chan float64
 	cluster, service string
-	ecs              *ecs.ECS
+	ecs              ecsiface.ECSAPI
 	min, max         int64
+
+	// Desired count last set, if any, to skip redundant updates. Changes to
+	// the service made elsewhere aren't noticed until beta changes.
+	desired    int64
+	hasDesired bool
+
+	// Called on errors updating the service, besides logging them
+	onError func(error)
 }
 
 func NewECSManager(cluster, service string) *ECSManager {
-	sess := session.Must(session.NewSession())
+	return NewECSManagerWithClients(defaultClients(), cluster, service)
+}
 
+func NewECSManagerWithClients(clients *Clients, cluster, service string) *ECSManager {
 	m := &ECSManager{
 		setB:    make(chan float64),
 		cluster: cluster,
 		service: service,
-		ecs:     ecs.New(sess),
+		ecs:     clients.ECS,
 	}
 
 	go m.run()
@@ -45,6 +55,9 @@ func (m *ECSManager) run() {
 			} else if m.max > 0 && v > m.max {
 				v = m.max
 			}
+			if m.hasDesired && v == m.desired {
+				continue
+			}
 			m.updateB(v)
 		}
 	}
@@ -58,26 +71,54 @@ func (m *ECSManager) SetLimits(min, max int64) {
 	m.max = max
 }
 
+// Set a function to be called with every error updating the service, e.g. to
+// count them. Must be called before beta is first set.
+func (m *ECSManager) SetErrorHandler(f func(error)) {
+	m.onError = f
+}
+
 func (m *ECSManager) SetB() chan float64 {
 	return m.setB
 }
 
 func (m *ECSManager) Beta() (uint, error) {
+	srv, err := m.describe()
+	if err != nil {
+		return 0, err
+	}
+	return uint(*srv.RunningCount), nil
+}
+
+// Implements control.PendingActuator. Tasks pending are those the service
+// reports as such, or those desired that aren't running yet if more, since
+// tasks just requested might not be pending yet.
+func (m *ECSManager) Workers() (running, desired, pending uint, err error) {
+	srv, err := m.describe()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	running = uint(aws.Int64Value(srv.RunningCount))
+	desired = uint(aws.Int64Value(srv.DesiredCount))
+	pending = uint(aws.Int64Value(srv.PendingCount))
+	if desired > running && desired-running > pending {
+		pending = desired - running
+	}
+	return running, desired, pending, nil
+}
+
+func (m *ECSManager) describe() (*ecs.Service, error) {
 	dso, err := m.ecs.DescribeServices(&ecs.DescribeServicesInput{
 		Cluster:  aws.String(m.cluster),
 		Services: []*string{aws.String(m.service)},
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	if len(dso.Services) != 1 {
-		return 0, fmt.Errorf("Service %s not found in cluster %s",
+		return nil, fmt.Errorf("Service %s not found in cluster %s",
 			m.service, m.cluster)
 	}
-	srv := dso.Services[0]
-
-	return uint(*srv.RunningCount), nil
-
+	return dso.Services[0], nil
 }
 
 func (m *ECSManager) updateB(b int64) {
@@ -89,6 +130,13 @@ func (m *ECSManager) updateB(b int64) {
 	if err != nil {
 		log.Printf("Error updating service %s in cluster %s: %s",
 			m.service, m.cluster, err)
+		if m.onError != nil {
+			m.onError(err)
+		}
+		// Retried on the next value, even if it's the same.
+		m.hasDesired = false
+		return
 	}
+	m.desired, m.hasDesired = b, true
 
 }
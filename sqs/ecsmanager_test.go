@@ -0,0 +1,47 @@
+package sqs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestECSManagerSkipsRedundantUpdates(t *testing.T) {
+	ecs := &fakeECS{running: 3, updates: make(chan int64, 4)}
+	m := NewECSManagerWithClients(fakeClients(newFakeSQS(nil), ecs), "cluster", "service")
+
+	for _, b := range []float64{3.2, 2.9, 3, 5} {
+		m.SetB() <- b
+	}
+
+	for _, expected := range []int64{3, 5} {
+		select {
+		case desired := <-ecs.updates:
+			if desired != expected {
+				t.Errorf("expected a desired count of %d, got %d", expected, desired)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for UpdateService")
+		}
+	}
+}
+
+func TestECSManagerWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		desired, pending int64
+		expected         uint
+	}{
+		{3, 0, 0},
+		{6, 2, 3}, // Tasks just requested aren't pending yet
+		{6, 3, 3},
+		{2, 0, 0}, // Draining
+	} {
+		ecs := &fakeECS{running: 3, desired: tc.desired, pending: tc.pending}
+		m := NewECSManagerWithClients(fakeClients(newFakeSQS(nil), ecs), "cluster", "service")
+
+		running, desired, pending, err := m.Workers()
+		if err != nil || running != 3 || desired != uint(tc.desired) || pending != tc.expected {
+			t.Errorf("%+v: expected %d pending, got %d running of %d and %d pending (%v)",
+				tc, tc.expected, running, desired, pending, err)
+		}
+	}
+}
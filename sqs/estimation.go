@@ -0,0 +1,24 @@
+package sqs
+
+import "github.com/Lowercases/queue-scaling/internal/sqsstats"
+
+// How an SQSManager estimates the arrival and departure rates. Failed attempts
+// are only estimated from CloudWatch.
+type Estimation = sqsstats.Estimation
+
+const (
+	// Rates from the CloudWatch metrics, see SetMetricPeriod. The default.
+	CloudWatch = sqsstats.CloudWatch
+
+	// Rates from successive samples of the queue attributes, plus the
+	// completions reported by the workers, without querying CloudWatch. The
+	// attributes only give away the net rate, dx - dy, so completions must
+	// be reported.
+	AttributeDelta = sqsstats.AttributeDelta
+
+	// Rates from the attributes and the completions reported by the workers
+	// when available, from CloudWatch otherwise. Without completions, dx is
+	// still estimated from the attributes on top of dy from CloudWatch, so
+	// that it doesn't lag behind.
+	Hybrid = sqsstats.Hybrid
+)
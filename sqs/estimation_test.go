@@ -0,0 +1,67 @@
+package sqs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/internal/fakesqs"
+)
+
+func TestAttributeDelta(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs": {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540},
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+
+	m := NewSQSManagerWithClients(clients, "jobs", time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+	m.SetEstimation(AttributeDelta)
+	sqs.Lock()
+	sqs.lastQuery = nil
+	sqs.Unlock()
+
+	// The baseline was taken on creation.
+	m.Completed(5)
+	m.setErr(m.updateStats())
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changed in the queue, so arrivals make up for completions.
+	if obs.DY <= 0 || obs.DX != obs.DY || obs.Q != 40 || obs.XmY != 46 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	sqs.Lock()
+	if sqs.lastQuery != nil {
+		t.Error("expected CloudWatch not to be queried")
+	}
+	sqs.Unlock()
+
+	m = NewSQSManagerWithClients(clients, "jobs", time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+	m.SetEstimation(AttributeDelta)
+	m.setErr(m.updateStats())
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error without completions")
+	}
+}
+
+func TestHybrid(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs": {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540},
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+
+	m := NewSQSManagerWithClients(clients, "jobs", time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+	m.SetEstimation(Hybrid)
+
+	// Without completions dy comes from CloudWatch, and dx follows the
+	// attributes since the sample taken on creation.
+	m.setErr(m.updateStats())
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.DX != 9 || obs.DY != 9 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+}
@@ -0,0 +1,120 @@
+package sqs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/internal/fakesqs"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Fake SQS and CloudWatch APIs, serving the queues in the map. Calls not
+// implemented panic through the nil embedded interfaces.
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	cloudwatchiface.CloudWatchAPI
+
+	queues    fakesqs.Queues
+	err       error
+	lastQuery *cloudwatch.GetMetricDataInput
+	sync.Mutex
+}
+
+func newFakeSQS(queues fakesqs.Queues) *fakeSQS {
+	return &fakeSQS{queues: queues}
+}
+
+func (f *fakeSQS) queue(name string) (*fakesqs.Queue, error) {
+	q, err := f.queues.Queue(name)
+	if err != nil {
+		return nil, err
+	}
+	return q, f.err
+}
+
+func (f *fakeSQS) GetQueueUrl(in *awssqs.GetQueueUrlInput) (*awssqs.GetQueueUrlOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, err := f.queue(*in.QueueName); err != nil {
+		return nil, err
+	}
+	return &awssqs.GetQueueUrlOutput{QueueUrl: aws.String(fakesqs.QueueUrl + *in.QueueName)}, nil
+}
+
+func (f *fakeSQS) GetQueueAttributes(in *awssqs.GetQueueAttributesInput) (*awssqs.GetQueueAttributesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	q, err := f.queue(strings.TrimPrefix(*in.QueueUrl, fakesqs.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+	attrs := q.Attributes(aws.StringValueSlice(in.AttributeNames))
+	return &awssqs.GetQueueAttributesOutput{Attributes: aws.StringMap(attrs)}, nil
+}
+
+func (f *fakeSQS) GetMetricData(in *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.lastQuery = in
+	out := &cloudwatch.GetMetricDataOutput{}
+	for _, mdq := range in.MetricDataQueries {
+		metric := mdq.MetricStat.Metric
+		q, err := f.queue(*metric.Dimensions[0].Value)
+		if err != nil {
+			return nil, err
+		}
+
+		period := time.Duration(*mdq.MetricStat.Period) * time.Second
+		timestamps, values, err := q.Datapoints(*metric.MetricName, period, *in.StartTime, *in.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		out.MetricDataResults = append(out.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         mdq.Id,
+			Timestamps: aws.TimeSlice(timestamps),
+			Values:     aws.Float64Slice(values),
+		})
+	}
+	return out, nil
+}
+
+// Fake ECS API with a single service, reporting every update.
+type fakeECS struct {
+	ecsiface.ECSAPI
+
+	running, desired, pending int64
+	updates                   chan int64
+	sync.Mutex
+}
+
+func (f *fakeECS) DescribeServices(in *ecs.DescribeServicesInput) (*ecs.DescribeServicesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	return &ecs.DescribeServicesOutput{Services: []*ecs.Service{{
+		ServiceName:  in.Services[0],
+		RunningCount: aws.Int64(f.running),
+		DesiredCount: aws.Int64(f.desired),
+		PendingCount: aws.Int64(f.pending),
+	}}}, nil
+}
+
+func (f *fakeECS) UpdateService(in *ecs.UpdateServiceInput) (*ecs.UpdateServiceOutput, error) {
+	f.updates <- *in.DesiredCount
+	return &ecs.UpdateServiceOutput{}, nil
+}
+
+func fakeClients(sqs *fakeSQS, ecs *fakeECS) *Clients {
+	return &Clients{SQS: sqs, CloudWatch: sqs, ECS: ecs}
+}
@@ -0,0 +1,37 @@
+package sqs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/internal/fakesqs"
+)
+
+func TestCustomMetrics(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs": {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540},
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+
+	m := NewSQSManagerWithClients(clients, "jobs", time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+	m.SetCustomMetrics("Jobs", "MessagesSent", "MessagesDone", "")
+	m.SetMetricPeriod(time.Second)
+	m.SetMetricAggregation(Average(5))
+	m.setErr(m.updateStats())
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(obs.DX-10) > 1e-9 || math.Abs(obs.DY-9) > 1e-9 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	sqs.Lock()
+	defer sqs.Unlock()
+	stat := sqs.lastQuery.MetricDataQueries[0].MetricStat
+	if *stat.Metric.Namespace != "Jobs" || *stat.Period != 1 {
+		t.Errorf("unexpected query %v", stat)
+	}
+}
@@ -0,0 +1,245 @@
+package sqs
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// A queue polled by the workers of a MultiSQSManager.
+type QueueConfig struct {
+	Name string
+
+	// Relative cost of a message from this queue, e.g. 2 for messages that
+	// take twice as long to process as those from a queue with weight 1.
+	// Defaults to 1.
+	Weight float64
+
+	// Maximum time messages should wait in this queue, if different from the
+	// controller's. Queued messages are scaled by the ratio between both, so
+	// that the controller drains the queue in time.
+	MaxQueueTime time.Duration
+}
+
+// Implements the control.Manager interface for several queues feeding a single
+// pool of workers, e.g. queues of different priorities. The statistics of the
+// queues are added together, weighted, into a single plant; the messages in
+// flight are added as they are, since each takes a worker whatever its weight.
+type MultiSQSManager struct {
+	clients *Clients
+	queues  []*sqsQueue
+	weights []float64 // Weight of every queue
+	urgency []float64 // Scale for Q of every queue, from the max queue times
+
+	// Stats, aggregated, rates per second
+	dx, dy, dr  float64
+	xmy, q      uint
+	deadLetters uint
+	age         float64 // Oldest, scaled by urgency
+	hasAge      bool
+
+	// Where rates are taken from
+	metrics metricConfig
+
+	// Stats have errored, returned when sampled
+	err error
+
+	// Guards stats, err and metrics, which are used in the background
+	sync.Mutex
+
+	control SQSControlManager
+}
+
+// Manager for queues, to be controlled with maxQueueTime.
+func NewMultiSQSManager(queues []QueueConfig, maxQueueTime, updatePeriod time.Duration, control SQSControlManager) *MultiSQSManager {
+	return NewMultiSQSManagerWithClients(defaultClients(), queues, maxQueueTime, updatePeriod, control)
+}
+
+func NewMultiSQSManagerWithClients(clients *Clients, queues []QueueConfig, maxQueueTime, updatePeriod time.Duration, control SQSControlManager) *MultiSQSManager {
+	m := &MultiSQSManager{clients: clients, control: control, metrics: defaultMetricConfig()}
+	for _, qc := range queues {
+		weight, urgency := qc.Weight, 1.0
+		if weight == 0 {
+			weight = 1
+		}
+		if qc.MaxQueueTime > 0 {
+			urgency = float64(maxQueueTime) / float64(qc.MaxQueueTime)
+		}
+
+		m.queues = append(m.queues, &sqsQueue{name: qc.Name})
+		m.weights = append(m.weights, weight)
+		m.urgency = append(m.urgency, urgency)
+	}
+
+	m.setErr(m.updateStats())
+	go m.run(updatePeriod)
+
+	return m
+}
+
+func (m *MultiSQSManager) run(updatePeriod time.Duration) {
+	for {
+		time.Sleep(updatePeriod)
+		m.setErr(m.updateStats())
+	}
+}
+
+func (m *MultiSQSManager) setErr(err error) {
+	m.Lock()
+	m.err = err
+	m.Unlock()
+}
+
+// Period of the CloudWatch metrics the rates are taken from, a minute by
+// default. Periods under a minute need high-resolution custom metrics, see
+// SetCustomMetrics. Applies from the next update of the stats, as do the
+// other metric settings.
+func (m *MultiSQSManager) SetMetricPeriod(period time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.SetPeriod(period)
+}
+
+// How far back metrics are queried, three minutes by default. Extended as
+// needed to cover the points the aggregation uses.
+func (m *MultiSQSManager) SetMetricLookback(lookback time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.Lookback = lookback
+}
+
+// How rates are computed from the metric datapoints, LastComplete by default.
+func (m *MultiSQSManager) SetMetricAggregation(a Aggregation) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.Aggregation = a
+}
+
+// Take rates from custom metrics, rather than from NumberOfMessagesSent,
+// NumberOfMessagesDeleted and NumberOfMessagesReceived in AWS/SQS, e.g.
+// high-resolution metrics published by producers and workers. Metrics must
+// have a QueueName dimension and be published as counts, so that their sum
+// over a period is the number of messages. Received is optional; without it
+// failed attempts aren't estimated.
+func (m *MultiSQSManager) SetCustomMetrics(namespace, sent, deleted, received string) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.SetCustom(namespace, sent, deleted, received)
+}
+
+func (m *MultiSQSManager) SetB() chan float64 {
+	return m.control.SetB()
+}
+
+func (m *MultiSQSManager) MuP() (float64, bool) {
+	return 0, false
+}
+
+func (m *MultiSQSManager) updateStats() error {
+	m.Lock()
+	mc := m.metrics
+	m.Unlock()
+
+	stats := make([]queueStats, len(m.queues))
+	for i, q := range m.queues {
+		s, err := q.stats(m.clients.SQS, m.clients.CloudWatch, mc)
+		if err != nil {
+			// A partial view would underestimate the load.
+			return fmt.Errorf("Queue %s: %s", q.name, err)
+		}
+		stats[i] = s
+	}
+
+	dx, dy, q, xmy := m.aggregate(stats)
+	dr, deadLetters := m.failures(stats)
+	age, hasAge := m.oldest(stats)
+
+	m.Lock()
+	m.dx, m.dy, m.dr = dx, dy, dr
+	m.q, m.xmy = q, xmy
+	m.deadLetters = deadLetters
+	m.age, m.hasAge = age, hasAge
+	m.Unlock()
+
+	return nil
+}
+
+// Add up the stats of every queue, weighted. Messages in flight aren't: a
+// message that's twice as expensive still takes a single worker, and W is
+// taken as the workers busy.
+func (m *MultiSQSManager) aggregate(stats []queueStats) (dx, dy float64, q, xmy uint) {
+	var fq float64
+	var w uint
+	for i, s := range stats {
+		dx += m.weights[i] * s.dx
+		dy += m.weights[i] * s.dy
+		fq += m.weights[i] * m.urgency[i] * float64(s.q)
+		w += s.w
+	}
+
+	q = uint(math.Round(fq))
+	return dx, dy, q, q + w
+}
+
+// Add up the failed attempts of every queue, weighted, and the messages in
+// their dead-letter queues, counting each once if shared.
+func (m *MultiSQSManager) failures(stats []queueStats) (dr float64, deadLetters uint) {
+	dlqs := map[string]bool{}
+	for i, s := range stats {
+		dr += m.weights[i] * s.dr
+
+		arn := m.queues[i].dlqArn
+		if arn != "" && !dlqs[arn] {
+			dlqs[arn] = true
+			deadLetters += s.deadLetters
+		}
+	}
+	return dr, deadLetters
+}
+
+// Age of the oldest message of every queue, scaled by its urgency as Q is, so
+// that it's compared against the controller's max queue time.
+func (m *MultiSQSManager) oldest(stats []queueStats) (age float64, ok bool) {
+	for i, s := range stats {
+		if s.hasAge {
+			age = math.Max(age, m.urgency[i]*s.age)
+			ok = true
+		}
+	}
+	return age, ok
+}
+
+func (m *MultiSQSManager) Sample(unit time.Duration) (control.Observation, error) {
+	beta, desired, pending, err := control.QueryWorkers(m.control)
+	if err != nil {
+		return control.Observation{}, fmt.Errorf("Error querying ECS manager: %s", err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return control.Observation{}, m.err
+	}
+
+	factor := float64(time.Second) / float64(unit)
+	var age *control.QueueAge
+	if m.hasAge {
+		age = &control.QueueAge{Oldest: m.age * factor}
+	}
+	return control.Observation{
+		DX:          m.dx / factor,
+		DY:          m.dy / factor,
+		DR:          m.dr / factor,
+		XmY:         m.xmy,
+		Q:           m.q,
+		Beta:        beta,
+		Desired:     desired,
+		Pending:     pending,
+		DeadLetters: m.deadLetters,
+		Age:         age,
+	}, nil
+}
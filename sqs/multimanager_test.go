@@ -0,0 +1,89 @@
+package sqs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/internal/fakesqs"
+)
+
+func TestMultiSQSManagerAggregate(t *testing.T) {
+	// High priority messages should wait a quarter of the time, low priority
+	// ones are twice as expensive.
+	m := &MultiSQSManager{
+		weights: []float64{1, 1, 2},
+		urgency: []float64{4, 1, 1},
+	}
+
+	dx, dy, q, xmy := m.aggregate([]queueStats{
+		{dx: 60, dy: 50, q: 10, w: 2},
+		{dx: 120, dy: 120, q: 0, w: 5},
+		{dx: 30, dy: 20, q: 7, w: 1},
+	})
+
+	if dx != 240 || dy != 210 {
+		t.Errorf("expected rates of 240 and 210, got %v and %v", dx, dy)
+	}
+	// Messages in flight take a worker each, whatever their weight.
+	if q != 54 || xmy != 62 {
+		t.Errorf("expected Q 54 and X - Y 62, got %d and %d", q, xmy)
+	}
+}
+
+func TestMultiSQSManagerFailures(t *testing.T) {
+	// Two queues share a dead-letter queue, which is counted once.
+	m := &MultiSQSManager{
+		queues: []*sqsQueue{
+			{name: "high", dlqArn: "arn:aws:sqs:eu-west-1:123456789012:dlq"},
+			{name: "low", dlqArn: "arn:aws:sqs:eu-west-1:123456789012:dlq"},
+			{name: "other", dlqArn: "arn:aws:sqs:eu-west-1:123456789012:other-dlq"},
+		},
+		weights: []float64{1, 2, 1},
+	}
+	dr, deadLetters := m.failures([]queueStats{
+		{dr: 1, deadLetters: 10},
+		{dr: 2, deadLetters: 10},
+		{dr: 0, deadLetters: 3},
+	})
+
+	if dr != 5 || deadLetters != 13 {
+		t.Errorf("expected 5 failed attempts and 13 dead letters, got %v and %d", dr, deadLetters)
+	}
+}
+
+func TestMultiSQSManagerOldest(t *testing.T) {
+	m := &MultiSQSManager{urgency: []float64{4, 1, 1}}
+	age, ok := m.oldest([]queueStats{
+		{age: 10, hasAge: true},
+		{age: 30, hasAge: true},
+		{},
+	})
+	if !ok || age != 40 {
+		t.Errorf("expected the high priority queue to be the oldest at 40, got %v, %v", age, ok)
+	}
+}
+
+func TestMultiSQSManagerSample(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"high": {Visible: 10, NotVisible: 2, Sent: 600, Deleted: 540},
+		"low":  {Visible: 7, NotVisible: 1, Sent: 120, Deleted: 60},
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+
+	m := NewMultiSQSManagerWithClients(clients, []QueueConfig{
+		{Name: "high", MaxQueueTime: 30 * time.Second},
+		{Name: "low", Weight: 2},
+	}, 2*time.Minute, time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.DX != 14 || obs.DY != 11 || obs.Beta != 3 {
+		t.Errorf("unexpected rates in %+v", obs)
+	}
+	// Queued messages are weighted, those in flight aren't.
+	if obs.Q != 54 || obs.XmY-obs.Q != 3 {
+		t.Errorf("expected Q 54 and W 3, got %+v", obs)
+	}
+}
@@ -2,253 +2,245 @@ package sqs
 
 import (
 	"fmt"
-	"strconv"
+	"math"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/Lowercases/queue-scaling/control"
+	"github.com/Lowercases/queue-scaling/internal/sqsstats"
 )
 
 // Whatever's implementing the control, likely an ECS Manager.
-type SQSControlManager interface {
-	SetB() chan float64
-	Beta() (uint, error)
-}
+type SQSControlManager = control.Actuator
 
 // Implements the control.Manager interface
 type SQSManager struct {
-	queue string
+	queue   *sqsQueue
+	clients *Clients
+
+	// Stats, rates per second
+	dx, dy, dr float64
+	xmy, q     uint
 
-	// Url for SQS API
-	queueUrl *string
+	// Age of the oldest message, in seconds, if known
+	age    float64
+	hasAge bool
 
-	// Stats
-	dx, dy float64
-	xmy, q uint
+	// Dead-letter queue depth, and its growth from successive samples
+	deadLetters uint
+	dlqGrowth   float64
+	dlq         sqsstats.DeltaEstimator
 
 	updatePeriod time.Duration
 
-	// Stats have errored, panic if queried
+	// Where rates are taken from
+	estimation Estimation
+	metrics    metricConfig
+	deltas     sqsstats.DeltaEstimator
+
+	// Stats have errored, returned when sampled
 	err error
 
+	// Guards stats, err and the estimation, which are used in the background
+	sync.Mutex
+
 	control SQSControlManager
 }
 
 func NewSQSManager(queue string, updatePeriod time.Duration, control SQSControlManager) *SQSManager {
+	return NewSQSManagerWithClients(defaultClients(), queue, updatePeriod, control)
+}
+
+func NewSQSManagerWithClients(clients *Clients, queue string, updatePeriod time.Duration, control SQSControlManager) *SQSManager {
 	m := &SQSManager{
-		queue:   queue,
+		queue:   &sqsQueue{name: queue},
+		clients: clients,
 		control: control,
+		metrics: defaultMetricConfig(),
 	}
 
-	m.err = m.updateStats()
+	m.setErr(m.updateStats())
 	go m.run(updatePeriod)
 
 	return m
 }
 
 func (m *SQSManager) run(updatePeriod time.Duration) {
+	// Stats have just been updated on creation.
 	for {
-		m.err = m.updateStats()
 		time.Sleep(updatePeriod)
+		m.setErr(m.updateStats())
 	}
 }
 
-func (m *SQSManager) SetB() chan float64 {
-	return m.control.SetB()
+func (m *SQSManager) setErr(err error) {
+	m.Lock()
+	m.err = err
+	m.Unlock()
 }
 
-func (m *SQSManager) Beta() uint {
-	beta, err := m.control.Beta()
-	if err != nil {
-		panic(fmt.Sprintf("Error querying ECS manager for %s: %s", m.queue, err))
-	}
-	return beta
+// Period of the CloudWatch metrics the rates are taken from, a minute by
+// default. Periods under a minute need high-resolution custom metrics, see
+// SetCustomMetrics. Applies from the next update of the stats, as do the
+// other metric settings.
+func (m *SQSManager) SetMetricPeriod(period time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.SetPeriod(period)
 }
 
-func (m *SQSManager) MuP() (float64, bool) {
-	return 0, false
+// How far back metrics are queried, three minutes by default. Extended as
+// needed to cover the points the aggregation uses.
+func (m *SQSManager) SetMetricLookback(lookback time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.Lookback = lookback
 }
 
-func (m *SQSManager) updateStats() error {
-	sess := session.Must(session.NewSession())
-	cw := cloudwatch.New(sess)
-	sqs := awssqs.New(sess)
+// How rates are computed from the metric datapoints, LastComplete by default.
+func (m *SQSManager) SetMetricAggregation(a Aggregation) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.Aggregation = a
+}
 
-	t := time.Now().UTC()
+// Take rates from custom metrics, rather than from NumberOfMessagesSent,
+// NumberOfMessagesDeleted and NumberOfMessagesReceived in AWS/SQS, e.g.
+// high-resolution metrics published by producers and workers. Metrics must
+// have a QueueName dimension and be published as counts, so that their sum
+// over a period is the number of messages. Received is optional; without it
+// failed attempts aren't estimated.
+func (m *SQSManager) SetCustomMetrics(namespace, sent, deleted, received string) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.SetCustom(namespace, sent, deleted, received)
+}
 
-	if m.queueUrl == nil {
-		gquo, err := sqs.GetQueueUrl(&awssqs.GetQueueUrlInput{
-			QueueName: aws.String(m.queue),
-		})
-		if err != nil {
-			return fmt.Errorf("Error querying SQS: GetQueueUrl: %s", err)
-		}
-		m.queueUrl = new(string)
-		*m.queueUrl = *gquo.QueueUrl
-	}
+func (m *SQSManager) SetB() chan float64 {
+	return m.control.SetB()
+}
 
-	// These could be got from CloudWatch, but it's best to get them from SQS
-	// given that a sleepy queue (one that hasn't got a message for six hours)
-	// will be considered "asleep" by AWS and will stop updating CloudWatch.
-	// In order to wake it up, the SQS API must be hit, so we might as well
-	// query these from SQS directly.
-	gqao, err := sqs.GetQueueAttributes(&awssqs.GetQueueAttributesInput{
-		QueueUrl: m.queueUrl,
-		AttributeNames: []*string{
-			aws.String("ApproximateNumberOfMessagesNotVisible"),
-			aws.String("ApproximateNumberOfMessages"),
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("Error querying SQS: GetQueueAttributes: %s", err)
-	}
+func (m *SQSManager) MuP() (float64, bool) {
+	return 0, false
+}
 
-	q, err := strconv.Atoi(*gqao.Attributes["ApproximateNumberOfMessages"])
-	if err != nil {
-		return fmt.Errorf("Error parsing SQS response: %s", err)
-	}
-	w, err := strconv.Atoi(*gqao.Attributes["ApproximateNumberOfMessagesNotVisible"])
-	if err != nil {
-		return fmt.Errorf("Error parsing SQS response: %s", err)
-	}
+// How rates are estimated, CloudWatch by default. The queue attributes are
+// sampled on creation whatever the estimation, so that rates are estimated
+// from the first update after this is called.
+func (m *SQSManager) SetEstimation(e Estimation) {
+	m.Lock()
+	defer m.Unlock()
+	m.estimation = e
+}
+
+// Report n messages completed by the workers, for AttributeDelta and Hybrid
+// estimation. Once reported, completions are expected for every update, so
+// that a period without any means no messages were completed.
+func (m *SQSManager) Completed(n uint) {
+	m.Lock()
+	defer m.Unlock()
+	m.deltas.Complete(n)
+}
+
+func (m *SQSManager) updateStats() error {
+	m.Lock()
+	est, mc := m.estimation, m.metrics
+	m.Unlock()
 
-	gmdo, err := cw.GetMetricData(&cloudwatch.GetMetricDataInput{
-		MetricDataQueries: []*cloudwatch.MetricDataQuery{
-			{
-				Id: aws.String("sent"),
-				MetricStat: &cloudwatch.MetricStat{
-					Metric: &cloudwatch.Metric{
-						Namespace:  aws.String("AWS/SQS"),
-						MetricName: aws.String("NumberOfMessagesSent"),
-						Dimensions: []*cloudwatch.Dimension{{
-							Name:  aws.String("QueueName"),
-							Value: aws.String(m.queue),
-						}},
-					},
-					Period: aws.Int64(60),
-					Stat:   aws.String("Sum"),
-				},
-			},
-			{
-				Id: aws.String("deleted"),
-				MetricStat: &cloudwatch.MetricStat{
-					Metric: &cloudwatch.Metric{
-						Namespace:  aws.String("AWS/SQS"),
-						MetricName: aws.String("NumberOfMessagesDeleted"),
-						Dimensions: []*cloudwatch.Dimension{{
-							Name:  aws.String("QueueName"),
-							Value: aws.String(m.queue),
-						}},
-					},
-					Period: aws.Int64(60),
-					Stat:   aws.String("Sum"),
-				},
-			},
-		},
-		StartTime: aws.Time(t.Add(-time.Minute * 3)),
-		EndTime:   aws.Time(t),
-	})
+	t := time.Now().UTC()
+	s, err := m.queue.attributes(m.clients.SQS)
 	if err != nil {
-		return fmt.Errorf("Error querying Cloudwatch: %s", err)
+		return err
 	}
 
-	// FIXME parametrise in function of the fucking array we're sending
-	if len(gmdo.MetricDataResults) != 2 {
-		return fmt.Errorf("Error querying Cloudwatch: expected 2 results, got %d", len(gmdo.MetricDataResults))
-	}
+	// Attributes are sampled whatever the estimation, so that the one taken
+	// on creation is the baseline for the deltas.
+	m.Lock()
+	net, dy, hasDY, ok := m.deltas.Sample(s.q+s.w, t)
+	m.Unlock()
 
-	var dx, dy float64
-	for _, results := range gmdo.MetricDataResults {
-		switch *results.Id {
-		case "sent":
-			dx, err = parseNextToLastMetric(results)
-		case "deleted":
-			dy, err = parseNextToLastMetric(results)
-		default:
-			err = fmt.Errorf("Unknown metric %s", *results.Id)
+	switch {
+	case est == CloudWatch:
+		r, err := m.queue.rates(m.clients.CloudWatch, mc, t)
+		if err != nil {
+			return err
 		}
+		s.setRates(r)
+		m.setStats(s, t)
+		return nil
+	case ok && hasDY:
+		s.dy = dy
+	case est == AttributeDelta && ok:
+		return fmt.Errorf("No completions reported for %s", m.queue.name)
+	case est == AttributeDelta:
+		return fmt.Errorf("Waiting for a second sample of %s", m.queue.name)
+	default:
+		// Hybrid, falling back to CloudWatch
+		r, err := m.queue.rates(m.clients.CloudWatch, mc, t)
 		if err != nil {
-			return fmt.Errorf("Error parsing metrics: %s", err)
+			return err
 		}
+		s.setRates(r)
+	}
+	if ok {
+		// Messages in the system only grow with arrivals.
+		s.dx = math.Max(net+s.dy, 0)
 	}
 
-	// It's ok to use data per minute
-	m.dx, m.dy = dx, dy
-	m.q, m.xmy = uint(q), uint(q+w)
-
+	m.setStats(s, t)
 	return nil
-
 }
 
-func (m *SQSManager) DXY(unit time.Duration) (float64, float64) {
-	if m.err != nil {
-		panic(m.err)
-	}
-
-	factor := float64(time.Minute / unit)
-	return m.dx / factor, m.dy / factor
-
-}
+func (m *SQSManager) setStats(s queueStats, t time.Time) {
+	m.Lock()
+	defer m.Unlock()
 
-func (m *SQSManager) Q() uint {
-	if m.err != nil {
-		panic(m.err)
+	m.dx, m.dy, m.dr = s.dx, s.dy, s.dr
+	m.q, m.xmy = s.q, s.q+s.w
+	m.deadLetters = s.deadLetters
+	m.age, m.hasAge = s.age, s.hasAge
+	if growth, _, _, ok := m.dlq.Sample(s.deadLetters, t); ok {
+		m.dlqGrowth = growth
 	}
-
-	return m.q
-
 }
 
-func (m *SQSManager) XmY() uint {
-	if m.err != nil {
-		panic(m.err)
-	}
-
-	return m.xmy
-}
-
-func parseNextToLastMetric(mr *cloudwatch.MetricDataResult) (float64, error) {
-	// At least two results are needed, since the last result might be incomplete.
-	// Search for the second to last metric.
-	if len(mr.Timestamps) < 2 {
-		return 0, fmt.Errorf("Expected at least two metrics, got %d", len(mr.Timestamps))
-	}
-
-	var last, second int
-	if mr.Timestamps[0].After(*mr.Timestamps[1]) {
-		last = 0
-		second = 1
-	} else {
-		last = 1
-		second = 0
-	}
-	for i := 2; i < len(mr.Timestamps); i++ {
-		if mr.Timestamps[i].After(*mr.Timestamps[last]) {
-			second = last
-			last = i
-		} else if mr.Timestamps[i].After(*mr.Timestamps[second]) {
-			second = i
-		}
-	}
-
-	return *mr.Values[second], nil
-
+// Messages in the dead-letter queue, and the rate at which they grow per
+// second, as of the last update. Zero if the queue has got no redrive policy.
+func (m *SQSManager) DeadLetters() (depth uint, growth float64) {
+	m.Lock()
+	defer m.Unlock()
+	return m.deadLetters, m.dlqGrowth
 }
 
-func parseLastMetric(mr *cloudwatch.MetricDataResult) (float64, error) {
-	if len(mr.Timestamps) < 1 {
-		return 0, fmt.Errorf("Expected at least one metrics, got %d", len(mr.Timestamps))
+func (m *SQSManager) Sample(unit time.Duration) (control.Observation, error) {
+	beta, desired, pending, err := control.QueryWorkers(m.control)
+	if err != nil {
+		return control.Observation{}, fmt.Errorf("Error querying ECS manager for %s: %s", m.queue.name, err)
 	}
 
-	last := 0
-	for i := 1; i < len(mr.Timestamps); i++ {
-		if mr.Timestamps[i].After(*mr.Timestamps[last]) {
-			last = i
-		}
-	}
+	m.Lock()
+	defer m.Unlock()
 
-	return *mr.Values[last], nil
+	if m.err != nil {
+		return control.Observation{}, m.err
+	}
+
+	factor := float64(time.Second) / float64(unit)
+	var age *control.QueueAge
+	if m.hasAge {
+		age = &control.QueueAge{Oldest: m.age * factor}
+	}
+	return control.Observation{
+		DX:          m.dx / factor,
+		DY:          m.dy / factor,
+		DR:          m.dr / factor,
+		XmY:         m.xmy,
+		Q:           m.q,
+		Beta:        beta,
+		Desired:     desired,
+		Pending:     pending,
+		DeadLetters: m.deadLetters,
+		Age:         age,
+	}, nil
 
 }
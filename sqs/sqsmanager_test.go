@@ -0,0 +1,124 @@
+package sqs
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+
+	"github.com/Lowercases/queue-scaling/internal/fakesqs"
+)
+
+func TestSQSManagerSample(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs": {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540},
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+
+	m := NewSQSManagerWithClients(clients, "jobs", time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rates are taken from the last complete minute.
+	if obs.DX != 10 || obs.DY != 9 || obs.Q != 40 || obs.XmY != 46 || obs.Beta != 3 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	sqs.Lock()
+	sqs.err = errors.New("Throttling: Rate exceeded")
+	sqs.Unlock()
+	m.setErr(m.updateStats())
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error after failing to query SQS")
+	}
+}
+
+// From queue statistics to UpdateService calls.
+func TestPipeline(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs": {Visible: 300, NotVisible: 4, Sent: 600, Deleted: 240},
+	})
+	ecs := &fakeECS{running: 4, updates: make(chan int64, 1)}
+	clients := fakeClients(sqs, ecs)
+
+	actuator := NewECSManagerWithClients(clients, "cluster", "service")
+	actuator.SetLimits(1, 20)
+	c := control.NewControl(NewSQSManagerWithClients(clients, "jobs", time.Hour, actuator), 60, 120, time.Second)
+
+	c.Step()
+	beta, set, err := c.Step()
+	if err != nil || !set {
+		t.Fatalf("expected beta to be set, got %v, %v", set, err)
+	}
+
+	// Four workers do 4 messages per second and 10 arrive, so the service
+	// should grow to at least 10 workers, to be capped at 20.
+	actuator.SetB() <- beta
+	select {
+	case desired := <-ecs.updates:
+		if desired != int64(math.Min(math.Round(beta), 20)) || desired < 10 {
+			t.Errorf("expected a desired count of %v, got %d", beta, desired)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for UpdateService")
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dlq := &fakesqs.Queue{Visible: 5, NotVisible: 2}
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs": {
+			Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540, Received: 720,
+			RedrivePolicy: `{"deadLetterTargetArn":"arn:aws:sqs:eu-west-1:123456789012:jobs-dlq","maxReceiveCount":5}`,
+		},
+		"jobs-dlq": dlq,
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+
+	m := NewSQSManagerWithClients(clients, "jobs", time.Hour, NewECSManagerWithClients(clients, "cluster", "service"))
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Receives that weren't deleted are failed attempts.
+	if obs.DR != 3 || obs.DeadLetters != 7 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	sqs.Lock()
+	dlq.Visible += 10
+	sqs.Unlock()
+	m.setErr(m.updateStats())
+	if depth, growth := m.DeadLetters(); depth != 17 || growth <= 0 {
+		t.Errorf("expected 17 dead letters and growing, got %d, %v", depth, growth)
+	}
+}
+
+func TestOldestMessageAge(t *testing.T) {
+	sqs := newFakeSQS(fakesqs.Queues{
+		"jobs":  {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540, Age: 90},
+		"empty": {},
+	})
+	clients := fakeClients(sqs, &fakeECS{running: 3})
+	actuator := NewECSManagerWithClients(clients, "cluster", "service")
+
+	obs, err := NewSQSManagerWithClients(clients, "jobs", time.Hour, actuator).Sample(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The newest point, in minutes
+	if obs.Age == nil || obs.Age.Oldest != 1.5 {
+		t.Errorf("expected the oldest message 1.5 minutes old, got %+v", obs.Age)
+	}
+
+	obs, err = NewSQSManagerWithClients(clients, "empty", time.Hour, actuator).Sample(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.Age != nil {
+		t.Errorf("expected no age for an empty queue, got %+v", obs.Age)
+	}
+}
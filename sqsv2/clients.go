@@ -0,0 +1,55 @@
+package sqsv2
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// SQS calls used by the managers.
+type SQSAPI interface {
+	GetQueueUrl(ctx context.Context, in *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	GetQueueAttributes(ctx context.Context, in *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// CloudWatch calls used by the managers.
+type CloudWatchAPI interface {
+	GetMetricData(ctx context.Context, in *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error)
+}
+
+// ECS calls used by the managers.
+type ECSAPI interface {
+	DescribeServices(ctx context.Context, in *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error)
+	UpdateService(ctx context.Context, in *ecs.UpdateServiceInput, optFns ...func(*ecs.Options)) (*ecs.UpdateServiceOutput, error)
+}
+
+// AWS clients used by the managers. They can be shared between managers, and
+// replaced by fakes for testing.
+type Clients struct {
+	SQS        SQSAPI
+	CloudWatch CloudWatchAPI
+	ECS        ECSAPI
+}
+
+// Clients for cfg, which sets the region, credentials provider and retryer.
+func NewClients(cfg aws.Config) *Clients {
+	return &Clients{
+		SQS:        sqs.NewFromConfig(cfg),
+		CloudWatch: cloudwatch.NewFromConfig(cfg),
+		ECS:        ecs.NewFromConfig(cfg),
+	}
+}
+
+// Clients for the default config, as configured by the environment. Options
+// such as config.WithRetryer or config.WithCredentialsProvider override it.
+func LoadClients(ctx context.Context, optFns ...func(*config.LoadOptions) error) (*Clients, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return NewClients(cfg), nil
+}
@@ -0,0 +1,141 @@
+package sqsv2
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Scales an ECS service, as sqs.ECSManager does, on top of the AWS SDK for Go
+// v2. Implements control.Actuator until the context it was created with is
+// done.
+type ECSManager struct {
+	ctx              context.Context
+	setB             chan float64
+	cluster, service string
+	ecs              ECSAPI
+	min, max         int64
+
+	// Desired count last set, if any, to skip redundant updates. Changes to
+	// the service made elsewhere aren't noticed until beta changes.
+	desired    int64
+	hasDesired bool
+
+	// Called on errors updating the service, besides logging them
+	onError func(error)
+}
+
+func NewECSManager(ctx context.Context, clients *Clients, cluster, service string) *ECSManager {
+	m := &ECSManager{
+		ctx:     ctx,
+		setB:    make(chan float64),
+		cluster: cluster,
+		service: service,
+		ecs:     clients.ECS,
+	}
+
+	go m.run()
+
+	return m
+}
+
+func (m *ECSManager) run() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case b := <-m.setB:
+			v := int64(math.Round(b))
+			if m.min > 0 && v < m.min {
+				v = m.min
+			} else if m.max > 0 && v > m.max {
+				v = m.max
+			}
+			if m.hasDesired && v == m.desired {
+				continue
+			}
+			m.updateB(v)
+		}
+	}
+}
+
+func (m *ECSManager) SetLimits(min, max int64) {
+	if max > 0 && min > max {
+		panic("min > max")
+	}
+	m.min = min
+	m.max = max
+}
+
+// Set a function to be called with every error updating the service, e.g. to
+// count them. Must be called before beta is first set.
+func (m *ECSManager) SetErrorHandler(f func(error)) {
+	m.onError = f
+}
+
+func (m *ECSManager) SetB() chan float64 {
+	return m.setB
+}
+
+func (m *ECSManager) Beta() (uint, error) {
+	srv, err := m.describe()
+	if err != nil {
+		return 0, err
+	}
+	return uint(srv.RunningCount), nil
+}
+
+// Implements control.PendingActuator. Tasks pending are those the service
+// reports as such, or those desired that aren't running yet if more, since
+// tasks just requested might not be pending yet.
+func (m *ECSManager) Workers() (running, desired, pending uint, err error) {
+	srv, err := m.describe()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	running, desired = uint(srv.RunningCount), uint(srv.DesiredCount)
+	pending = uint(srv.PendingCount)
+	if desired > running && desired-running > pending {
+		pending = desired - running
+	}
+	return running, desired, pending, nil
+}
+
+func (m *ECSManager) describe() (ecstypes.Service, error) {
+	dso, err := m.ecs.DescribeServices(m.ctx, &ecs.DescribeServicesInput{
+		Cluster:  aws.String(m.cluster),
+		Services: []string{m.service},
+	})
+	if err != nil {
+		return ecstypes.Service{}, err
+	}
+	if len(dso.Services) != 1 {
+		return ecstypes.Service{}, fmt.Errorf("Service %s not found in cluster %s",
+			m.service, m.cluster)
+	}
+	return dso.Services[0], nil
+}
+
+func (m *ECSManager) updateB(b int64) {
+	_, err := m.ecs.UpdateService(m.ctx, &ecs.UpdateServiceInput{
+		Cluster:      aws.String(m.cluster),
+		Service:      aws.String(m.service),
+		DesiredCount: aws.Int32(int32(b)),
+	})
+	if err != nil {
+		log.Printf("Error updating service %s in cluster %s: %s",
+			m.service, m.cluster, err)
+		if m.onError != nil {
+			m.onError(err)
+		}
+		// Retried on the next value, even if it's the same.
+		m.hasDesired = false
+		return
+	}
+	m.desired, m.hasDesired = b, true
+}
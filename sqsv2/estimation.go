@@ -0,0 +1,20 @@
+package sqsv2
+
+import "github.com/Lowercases/queue-scaling/internal/sqsstats"
+
+// How an SQSManager estimates the arrival and departure rates, as with
+// sqs.Estimation. Failed attempts are only estimated from CloudWatch.
+type Estimation = sqsstats.Estimation
+
+const (
+	// Rates from the CloudWatch metrics, see SetMetricPeriod. The default.
+	CloudWatch = sqsstats.CloudWatch
+
+	// Rates from successive samples of the queue attributes, plus the
+	// completions reported by the workers, as with sqs.AttributeDelta.
+	AttributeDelta = sqsstats.AttributeDelta
+
+	// Rates from the attributes and the completions reported by the workers
+	// when available, from CloudWatch otherwise, as with sqs.Hybrid.
+	Hybrid = sqsstats.Hybrid
+)
@@ -0,0 +1,74 @@
+package sqsv2
+
+import (
+	"time"
+
+	"github.com/Lowercases/queue-scaling/internal/sqsstats"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// How the rates are computed from the CloudWatch datapoints, as with
+// sqs.Aggregation.
+type Aggregation = sqsstats.Aggregation
+
+// Take the last complete datapoint, i.e. the next to last one. This is the
+// default, and lags behind by one to two periods.
+func LastComplete() Aggregation {
+	return sqsstats.LastComplete()
+}
+
+// Take the average of the last n complete datapoints. Smoother, but lags
+// behind further.
+func Average(n int) Aggregation {
+	return sqsstats.Average(n)
+}
+
+// Take the last datapoint, which is likely incomplete, extrapolated to the
+// whole period. Doesn't lag, but it's noisy, and underestimates the rates if
+// CloudWatch hasn't ingested every datum in the period yet.
+func Extrapolated() Aggregation {
+	return sqsstats.Extrapolated()
+}
+
+// Where the rates are taken from, queried through the SDK.
+type metricConfig struct {
+	sqsstats.Metrics
+}
+
+func defaultMetricConfig() metricConfig {
+	return metricConfig{sqsstats.DefaultMetrics()}
+}
+
+func (mc metricConfig) query(id, metric, queue string) cwtypes.MetricDataQuery {
+	return mc.queryStat(id, mc.Namespace, metric, queue, "Sum")
+}
+
+func (mc metricConfig) queryStat(id, namespace, metric, queue, stat string) cwtypes.MetricDataQuery {
+	return cwtypes.MetricDataQuery{
+		Id: aws.String(id),
+		MetricStat: &cwtypes.MetricStat{
+			Metric: &cwtypes.Metric{
+				Namespace:  aws.String(namespace),
+				MetricName: aws.String(metric),
+				Dimensions: []cwtypes.Dimension{{
+					Name:  aws.String("QueueName"),
+					Value: aws.String(queue),
+				}},
+			},
+			Period: aws.Int32(int32(mc.Period / time.Second)),
+			Stat:   aws.String(stat),
+		},
+	}
+}
+
+// Rate per second from the datapoints of a metric, as of now.
+func (mc metricConfig) rate(mr cwtypes.MetricDataResult, now time.Time) (float64, error) {
+	return mc.Rate(mr.Timestamps, mr.Values, now)
+}
+
+// Newest value of a metric.
+func latest(mr cwtypes.MetricDataResult) (float64, error) {
+	return sqsstats.Latest(mr.Timestamps, mr.Values)
+}
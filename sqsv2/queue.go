@@ -0,0 +1,213 @@
+package sqsv2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/internal/sqsstats"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Statistics of a single queue, with rates per second.
+type queueStats struct {
+	dx, dy, dr float64
+	q, w       uint
+
+	// Messages in the dead-letter queue, if any
+	deadLetters uint
+
+	// Age of the oldest message queued up, in seconds, if known
+	age    float64
+	hasAge bool
+}
+
+// An SQS queue whose statistics are queried.
+type sqsQueue struct {
+	name string
+
+	// Account owning the queue, if it isn't the caller's
+	owner string
+
+	// Url for SQS API
+	url *string
+
+	// Dead-letter queue, from the redrive policy, if any
+	dlq    *sqsQueue
+	dlqArn string
+}
+
+// Take the rates, and the age if any, from r.
+func (s *queueStats) setRates(r queueStats) {
+	s.dx, s.dy, s.dr = r.dx, r.dy, r.dr
+	s.age, s.hasAge = r.age, r.hasAge
+}
+
+// Messages queued, in flight and dead-lettered, without rates.
+func (q *sqsQueue) attributes(ctx context.Context, api SQSAPI) (queueStats, error) {
+	// Queried from SQS rather than CloudWatch to wake up sleepy queues, see
+	// sqs.SQSManager.
+	attrs, err := q.getAttributes(ctx, api,
+		sqstypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		sqstypes.QueueAttributeNameApproximateNumberOfMessages,
+		sqstypes.QueueAttributeNameRedrivePolicy,
+	)
+	if err != nil {
+		return queueStats{}, err
+	}
+
+	queued, err := strconv.Atoi(attrs["ApproximateNumberOfMessages"])
+	if err != nil {
+		return queueStats{}, fmt.Errorf("Error parsing SQS response: %s", err)
+	}
+	w, err := strconv.Atoi(attrs["ApproximateNumberOfMessagesNotVisible"])
+	if err != nil {
+		return queueStats{}, fmt.Errorf("Error parsing SQS response: %s", err)
+	}
+	s := queueStats{q: uint(queued), w: uint(w)}
+
+	// The redrive policy is checked on every update, since it can be changed
+	// at any time.
+	if err := q.setRedrivePolicy(attrs["RedrivePolicy"]); err != nil {
+		return queueStats{}, err
+	}
+	if q.dlq != nil {
+		s.deadLetters, err = q.dlq.depth(ctx, api)
+		if err != nil {
+			return queueStats{}, fmt.Errorf("Dead-letter queue %s: %s", q.dlq.name, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Messages in the queue, visible or not.
+func (q *sqsQueue) depth(ctx context.Context, api SQSAPI) (uint, error) {
+	attrs, err := q.getAttributes(ctx, api,
+		sqstypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+		sqstypes.QueueAttributeNameApproximateNumberOfMessages,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var depth int
+	for _, v := range attrs {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("Error parsing SQS response: %s", err)
+		}
+		depth += n
+	}
+	return uint(depth), nil
+}
+
+func (q *sqsQueue) getAttributes(ctx context.Context, api SQSAPI, names ...sqstypes.QueueAttributeName) (map[string]string, error) {
+	if q.url == nil {
+		in := &sqs.GetQueueUrlInput{QueueName: aws.String(q.name)}
+		if q.owner != "" {
+			in.QueueOwnerAWSAccountId = aws.String(q.owner)
+		}
+		gquo, err := api.GetQueueUrl(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("Error querying SQS: GetQueueUrl: %s", err)
+		}
+		q.url = gquo.QueueUrl
+	}
+
+	gqao, err := api.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       q.url,
+		AttributeNames: names,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error querying SQS: GetQueueAttributes: %s", err)
+	}
+	return gqao.Attributes, nil
+}
+
+// Follow the dead-letter queue in policy, a JSON redrive policy, or none if
+// empty.
+func (q *sqsQueue) setRedrivePolicy(policy string) error {
+	arn, err := sqsstats.DeadLetterTarget(policy)
+	if err != nil {
+		return err
+	}
+
+	if arn == q.dlqArn {
+		return nil
+	}
+	q.dlq, q.dlqArn = nil, arn
+	if q.dlqArn == "" {
+		return nil
+	}
+
+	name, owner, err := sqsstats.ParseQueueARN(q.dlqArn)
+	if err != nil {
+		return fmt.Errorf("Dead-letter queue: %s", err)
+	}
+	q.dlq = &sqsQueue{name: name, owner: owner}
+	return nil
+}
+
+// Rates per second from CloudWatch, as of t. Failed attempts are the messages
+// received but not deleted, if there's a metric for receives. The age of the
+// oldest message is taken from SQS metrics, even with custom ones for the
+// rates.
+func (q *sqsQueue) rates(ctx context.Context, cw CloudWatchAPI, mc metricConfig, t time.Time) (queueStats, error) {
+	queries := []cwtypes.MetricDataQuery{
+		mc.query("sent", mc.Sent, q.name),
+		mc.query("deleted", mc.Deleted, q.name),
+	}
+	if mc.Received != "" {
+		queries = append(queries, mc.query("received", mc.Received, q.name))
+	}
+	queries = append(queries, mc.queryStat("age", "AWS/SQS", "ApproximateAgeOfOldestMessage", q.name, "Maximum"))
+
+	gmdo, err := cw.GetMetricData(ctx, &cloudwatch.GetMetricDataInput{
+		MetricDataQueries: queries,
+		StartTime:         aws.Time(t.Add(-mc.Window())),
+		EndTime:           aws.Time(t),
+	})
+	if err != nil {
+		return queueStats{}, fmt.Errorf("Error querying Cloudwatch: %s", err)
+	}
+
+	if len(gmdo.MetricDataResults) != len(queries) {
+		return queueStats{}, fmt.Errorf("Error querying Cloudwatch: expected %d results, got %d", len(queries), len(gmdo.MetricDataResults))
+	}
+
+	var s queueStats
+	var received float64
+	for _, results := range gmdo.MetricDataResults {
+		switch aws.ToString(results.Id) {
+		case "sent":
+			s.dx, err = mc.rate(results, t)
+		case "deleted":
+			s.dy, err = mc.rate(results, t)
+		case "received":
+			received, err = mc.rate(results, t)
+		case "age":
+			// Missing for empty queues
+			if len(results.Values) > 0 {
+				s.age, err = latest(results)
+				s.hasAge = err == nil
+			}
+		default:
+			err = fmt.Errorf("Unknown metric %s", aws.ToString(results.Id))
+		}
+		if err != nil {
+			return queueStats{}, fmt.Errorf("Error parsing metrics: %s", err)
+		}
+	}
+
+	if received > s.dy {
+		s.dr = received - s.dy
+	}
+	return s, nil
+}
@@ -0,0 +1,241 @@
+package sqsv2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+	"github.com/Lowercases/queue-scaling/internal/sqsstats"
+)
+
+// Implements the control.Manager interface, as sqs.SQSManager does, on top of
+// the AWS SDK for Go v2. Statistics are updated in the background until the
+// context the manager was created with is done.
+type SQSManager struct {
+	ctx     context.Context
+	clients *Clients
+	queue   *sqsQueue
+
+	// Stats, rates per second
+	dx, dy, dr float64
+	xmy, q     uint
+
+	// Age of the oldest message, in seconds, if known
+	age    float64
+	hasAge bool
+
+	// Dead-letter queue depth, and its growth from successive samples
+	deadLetters uint
+	dlqGrowth   float64
+	dlq         sqsstats.DeltaEstimator
+
+	// Where rates are taken from
+	estimation Estimation
+	metrics    metricConfig
+	deltas     sqsstats.DeltaEstimator
+
+	// Stats have errored, returned when sampled
+	err error
+
+	// Guards stats, err and the estimation, which are used in the background
+	sync.Mutex
+
+	control control.Actuator
+}
+
+func NewSQSManager(ctx context.Context, clients *Clients, queue string, updatePeriod time.Duration, control control.Actuator) *SQSManager {
+	m := &SQSManager{
+		ctx:     ctx,
+		clients: clients,
+		queue:   &sqsQueue{name: queue},
+		control: control,
+		metrics: defaultMetricConfig(),
+	}
+
+	m.setErr(m.updateStats(ctx))
+	go m.run(updatePeriod)
+
+	return m
+}
+
+func (m *SQSManager) run(updatePeriod time.Duration) {
+	t := time.NewTicker(updatePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.setErr(m.ctx.Err())
+			return
+		case <-t.C:
+			m.setErr(m.updateStats(m.ctx))
+		}
+	}
+}
+
+func (m *SQSManager) setErr(err error) {
+	m.Lock()
+	m.err = err
+	m.Unlock()
+}
+
+// Period of the CloudWatch metrics the rates are taken from, a minute by
+// default, as with sqs.SQSManager.SetMetricPeriod.
+func (m *SQSManager) SetMetricPeriod(period time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.SetPeriod(period)
+}
+
+// How far back metrics are queried, three minutes by default. Extended as
+// needed to cover the points the aggregation uses.
+func (m *SQSManager) SetMetricLookback(lookback time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.Lookback = lookback
+}
+
+// How rates are computed from the metric datapoints, LastComplete by default.
+func (m *SQSManager) SetMetricAggregation(a Aggregation) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.Aggregation = a
+}
+
+// Take rates from custom metrics, as with sqs.SQSManager.SetCustomMetrics.
+func (m *SQSManager) SetCustomMetrics(namespace, sent, deleted, received string) {
+	m.Lock()
+	defer m.Unlock()
+	m.metrics.SetCustom(namespace, sent, deleted, received)
+}
+
+func (m *SQSManager) SetB() chan float64 {
+	return m.control.SetB()
+}
+
+func (m *SQSManager) MuP() (float64, bool) {
+	return 0, false
+}
+
+// How rates are estimated, CloudWatch by default. The queue attributes are
+// sampled on creation whatever the estimation, so that rates are estimated
+// from the first update after this is called.
+func (m *SQSManager) SetEstimation(e Estimation) {
+	m.Lock()
+	defer m.Unlock()
+	m.estimation = e
+}
+
+// Report n messages completed by the workers, for AttributeDelta and Hybrid
+// estimation. Once reported, completions are expected for every update, so
+// that a period without any means no messages were completed.
+func (m *SQSManager) Completed(n uint) {
+	m.Lock()
+	defer m.Unlock()
+	m.deltas.Complete(n)
+}
+
+func (m *SQSManager) updateStats(ctx context.Context) error {
+	m.Lock()
+	est, mc := m.estimation, m.metrics
+	m.Unlock()
+
+	t := time.Now().UTC()
+	s, err := m.queue.attributes(ctx, m.clients.SQS)
+	if err != nil {
+		return err
+	}
+
+	// Attributes are sampled whatever the estimation, so that the one taken
+	// on creation is the baseline for the deltas.
+	m.Lock()
+	net, dy, hasDY, ok := m.deltas.Sample(s.q+s.w, t)
+	m.Unlock()
+
+	switch {
+	case est == CloudWatch:
+		r, err := m.queue.rates(ctx, m.clients.CloudWatch, mc, t)
+		if err != nil {
+			return err
+		}
+		s.setRates(r)
+		m.setStats(s, t)
+		return nil
+	case ok && hasDY:
+		s.dy = dy
+	case est == AttributeDelta && ok:
+		return fmt.Errorf("No completions reported for %s", m.queue.name)
+	case est == AttributeDelta:
+		return fmt.Errorf("Waiting for a second sample of %s", m.queue.name)
+	default:
+		// Hybrid, falling back to CloudWatch
+		r, err := m.queue.rates(ctx, m.clients.CloudWatch, mc, t)
+		if err != nil {
+			return err
+		}
+		s.setRates(r)
+	}
+	if ok {
+		// Messages in the system only grow with arrivals.
+		s.dx = math.Max(net+s.dy, 0)
+	}
+
+	m.setStats(s, t)
+	return nil
+}
+
+func (m *SQSManager) setStats(s queueStats, t time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.dx, m.dy, m.dr = s.dx, s.dy, s.dr
+	m.q, m.xmy = s.q, s.q+s.w
+	m.deadLetters = s.deadLetters
+	m.age, m.hasAge = s.age, s.hasAge
+	if growth, _, _, ok := m.dlq.Sample(s.deadLetters, t); ok {
+		m.dlqGrowth = growth
+	}
+}
+
+// Messages in the dead-letter queue, and the rate at which they grow per
+// second, as of the last update. Zero if the queue has got no redrive policy.
+func (m *SQSManager) DeadLetters() (depth uint, growth float64) {
+	m.Lock()
+	defer m.Unlock()
+	return m.deadLetters, m.dlqGrowth
+}
+
+func (m *SQSManager) Sample(unit time.Duration) (control.Observation, error) {
+	beta, desired, pending, err := control.QueryWorkers(m.control)
+	if err != nil {
+		return control.Observation{}, fmt.Errorf("Error querying ECS manager for %s: %s", m.queue.name, err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return control.Observation{}, m.err
+	}
+
+	factor := float64(time.Second) / float64(unit)
+	var age *control.QueueAge
+	if m.hasAge {
+		age = &control.QueueAge{Oldest: m.age * factor}
+	}
+	return control.Observation{
+		DX:          m.dx / factor,
+		DY:          m.dy / factor,
+		DR:          m.dr / factor,
+		XmY:         m.xmy,
+		Q:           m.q,
+		Beta:        beta,
+		Desired:     desired,
+		Pending:     pending,
+		DeadLetters: m.deadLetters,
+		Age:         age,
+	}, nil
+}
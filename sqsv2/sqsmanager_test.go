@@ -0,0 +1,262 @@
+package sqsv2
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+	"github.com/Lowercases/queue-scaling/internal/fakesqs"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+var _ control.Actuator = (*ECSManager)(nil)
+
+// Fake SQS, CloudWatch and ECS APIs, serving the queues in the map and a
+// service.
+type fakeAWS struct {
+	queues    fakesqs.Queues
+	running   int32
+	updates   chan int32
+	err       error
+	lastQuery *cloudwatch.GetMetricDataInput
+	sync.Mutex
+}
+
+func (f *fakeAWS) queue(name string) (*fakesqs.Queue, error) {
+	q, err := f.queues.Queue(name)
+	if err != nil {
+		return nil, err
+	}
+	return q, f.err
+}
+
+func (f *fakeAWS) GetQueueUrl(ctx context.Context, in *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, err := f.queue(*in.QueueName); err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(fakesqs.QueueUrl + *in.QueueName)}, nil
+}
+
+func (f *fakeAWS) GetQueueAttributes(ctx context.Context, in *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	q, err := f.queue(strings.TrimPrefix(*in.QueueUrl, fakesqs.QueueUrl))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(in.AttributeNames))
+	for i, name := range in.AttributeNames {
+		names[i] = string(name)
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: q.Attributes(names)}, nil
+}
+
+func (f *fakeAWS) GetMetricData(ctx context.Context, in *cloudwatch.GetMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricDataOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.lastQuery = in
+	out := &cloudwatch.GetMetricDataOutput{}
+	for _, mdq := range in.MetricDataQueries {
+		metric := mdq.MetricStat.Metric
+		q, err := f.queue(*metric.Dimensions[0].Value)
+		if err != nil {
+			return nil, err
+		}
+
+		period := time.Duration(*mdq.MetricStat.Period) * time.Second
+		timestamps, values, err := q.Datapoints(*metric.MetricName, period, *in.StartTime, *in.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		out.MetricDataResults = append(out.MetricDataResults, cwtypes.MetricDataResult{
+			Id:         mdq.Id,
+			Timestamps: timestamps,
+			Values:     values,
+		})
+	}
+	return out, nil
+}
+
+func (f *fakeAWS) DescribeServices(ctx context.Context, in *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+	return &ecs.DescribeServicesOutput{Services: []ecstypes.Service{{
+		ServiceName:  aws.String(in.Services[0]),
+		RunningCount: f.running,
+	}}}, nil
+}
+
+func (f *fakeAWS) UpdateService(ctx context.Context, in *ecs.UpdateServiceInput, optFns ...func(*ecs.Options)) (*ecs.UpdateServiceOutput, error) {
+	f.updates <- *in.DesiredCount
+	return &ecs.UpdateServiceOutput{}, nil
+}
+
+func TestManagers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := &fakeAWS{
+		queues:  fakesqs.Queues{"jobs": {Visible: 300, NotVisible: 4, Sent: 600, Deleted: 240}},
+		running: 4,
+		updates: make(chan int32, 1),
+	}
+	clients := &Clients{SQS: f, CloudWatch: f, ECS: f}
+
+	actuator := NewECSManager(ctx, clients, "cluster", "service")
+	actuator.SetLimits(1, 8)
+	m := NewSQSManager(ctx, clients, "jobs", time.Hour, actuator)
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.DX != 10 || obs.DY != 4 || obs.Q != 300 || obs.XmY != 304 || obs.Beta != 4 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	actuator.SetB() <- 12.4
+	select {
+	case desired := <-f.updates:
+		if desired != 8 {
+			t.Errorf("expected a desired count capped to 8, got %d", desired)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for UpdateService")
+	}
+
+	f.Lock()
+	f.err = errors.New("api error Throttling: Rate exceeded")
+	f.Unlock()
+	m.setErr(m.updateStats(ctx))
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error after failing to query SQS")
+	}
+}
+
+func TestMetricSettings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := &fakeAWS{
+		queues:  fakesqs.Queues{"jobs": {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540}},
+		running: 3,
+	}
+	clients := &Clients{SQS: f, CloudWatch: f, ECS: f}
+
+	m := NewSQSManager(ctx, clients, "jobs", time.Hour, NewECSManager(ctx, clients, "cluster", "service"))
+	m.SetCustomMetrics("Jobs", "MessagesSent", "MessagesDone", "")
+	m.SetMetricPeriod(time.Second)
+	m.SetMetricAggregation(Average(5))
+	m.setErr(m.updateStats(ctx))
+
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(obs.DX-10) > 1e-9 || math.Abs(obs.DY-9) > 1e-9 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	stat := f.lastQuery.MetricDataQueries[0].MetricStat
+	if *stat.Metric.Namespace != "Jobs" || *stat.Period != 1 {
+		t.Errorf("unexpected query %v", stat)
+	}
+}
+
+func TestDeadLettersAndAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dlq := &fakesqs.Queue{Visible: 5, NotVisible: 2}
+	f := &fakeAWS{
+		queues: fakesqs.Queues{
+			"jobs": {
+				Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540, Received: 720,
+				RedrivePolicy: `{"deadLetterTargetArn":"arn:aws:sqs:eu-west-1:123456789012:jobs-dlq","maxReceiveCount":5}`,
+				Age:           90,
+			},
+			"jobs-dlq": dlq,
+		},
+		running: 3,
+	}
+	clients := &Clients{SQS: f, CloudWatch: f, ECS: f}
+
+	m := NewSQSManager(ctx, clients, "jobs", time.Hour, NewECSManager(ctx, clients, "cluster", "service"))
+	obs, err := m.Sample(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Receives that weren't deleted are failed attempts, per minute.
+	if obs.DR != 180 || obs.DeadLetters != 7 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	if obs.Age == nil || obs.Age.Oldest != 1.5 {
+		t.Errorf("expected the oldest message 1.5 minutes old, got %+v", obs.Age)
+	}
+
+	f.Lock()
+	dlq.Visible += 10
+	f.Unlock()
+	m.setErr(m.updateStats(ctx))
+	if depth, growth := m.DeadLetters(); depth != 17 || growth <= 0 {
+		t.Errorf("expected 17 dead letters and growing, got %d, %v", depth, growth)
+	}
+}
+
+func TestAttributeDelta(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := &fakeAWS{
+		queues:  fakesqs.Queues{"jobs": {Visible: 40, NotVisible: 6, Sent: 600, Deleted: 540}},
+		running: 3,
+	}
+	clients := &Clients{SQS: f, CloudWatch: f, ECS: f}
+
+	m := NewSQSManager(ctx, clients, "jobs", time.Hour, NewECSManager(ctx, clients, "cluster", "service"))
+	m.SetEstimation(AttributeDelta)
+	f.Lock()
+	f.lastQuery = nil
+	f.Unlock()
+
+	// The baseline was taken on creation.
+	m.Completed(5)
+	m.setErr(m.updateStats(ctx))
+	obs, err := m.Sample(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changed in the queue, so arrivals make up for completions.
+	if obs.DY <= 0 || obs.DX != obs.DY || obs.Q != 40 || obs.XmY != 46 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	f.Lock()
+	if f.lastQuery != nil {
+		t.Error("expected CloudWatch not to be queried")
+	}
+	f.Unlock()
+
+	m = NewSQSManager(ctx, clients, "jobs", time.Hour, NewECSManager(ctx, clients, "cluster", "service"))
+	m.SetEstimation(AttributeDelta)
+	m.setErr(m.updateStats(ctx))
+	if _, err := m.Sample(time.Second); err == nil {
+		t.Error("expected an error without completions")
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
 )
 
 type Generator struct {
 	logMu, logSigma float64
 	unit            time.Duration
 	plant           Plant
+	clock           clock.Clock
 	killed          bool
 	sync.Mutex      // For kill
 }
@@ -21,17 +24,23 @@ func NewGenerator(plant Plant, logMu, logSigma float64, unit time.Duration) *Gen
 		logSigma: logSigma,
 		unit:     unit,
 		plant:    plant,
+		clock:    clock.Real{},
 		killed:   false,
 	}
 }
 
+// Use clk instead of the wall clock. Must be called before Start.
+func (g *Generator) SetClock(clk clock.Clock) {
+	g.clock = clk
+}
+
 func (g *Generator) Start() {
 	go g.run()
 }
 
 func (g *Generator) run() {
 	for {
-		LogSleep(g.logMu, g.logSigma, g.unit)
+		g.clock.Sleep(LogDuration(g.logMu, g.logSigma, g.unit))
 
 		g.Lock()
 		if g.killed {
@@ -60,16 +69,25 @@ func (g *Generator) Burst(relMu, size uint) {
 func (g *Generator) burst(burstLogMu float64, size uint) {
 	var i uint
 	for i = 0; i < size; i++ {
-		LogSleep(burstLogMu, g.logSigma, g.unit)
+		g.clock.Sleep(LogDuration(burstLogMu, g.logSigma, g.unit))
 		g.plant.Message() <- *new(struct{})
 	}
 }
 
 // Convenience function for sleeping
 func LogSleep(logMu, logSigma float64, unit time.Duration) {
-	r := math.Exp(rand.NormFloat64()*logSigma + logMu)
+	time.Sleep(LogDuration(logMu, logSigma, unit))
+}
+
+// Log-normally distributed duration, in units.
+func LogDuration(logMu, logSigma float64, unit time.Duration) time.Duration {
+	return logDuration(rand.NormFloat64(), logMu, logSigma, unit)
+}
+
+// Log-normally distributed duration given a standard normal sample.
+func logDuration(norm, logMu, logSigma float64, unit time.Duration) time.Duration {
+	r := math.Exp(norm*logSigma + logMu)
 	// Increase precision to nanoseconds
 	r *= float64(unit / time.Nanosecond)
-	sl := time.Duration(r)
-	time.Sleep(sl)
+	return time.Duration(r)
 }
@@ -0,0 +1,23 @@
+package testplant
+
+import (
+	"math"
+	"sort"
+)
+
+// Percentile of the time in queue reported by default.
+const defaultPercentile = 0.95
+
+// Nearest-rank percentile p of samples, which are sorted in place. Zero if
+// there are none.
+func percentile(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Float64s(samples)
+	i := int(math.Ceil(p*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return samples[i]
+}
@@ -4,6 +4,9 @@ import (
 	"math"
 	"sync"
 	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+	"github.com/Lowercases/queue-scaling/control"
 )
 
 type Manager struct {
@@ -16,23 +19,34 @@ type Manager struct {
 	// State
 	// Workers -- they need concurrency
 	workers      []*Worker // Active workers
+	draining     []*Worker // Stopped, finishing their message
 	workersMutex sync.Mutex
 
+	// Messages of killed workers to be returned to the queue, and closed
+	// once the manager stops
+	requeue chan struct{}
+	stopped chan struct{}
+
 	queue *Queue // Pending work for workers
 	x, y  uint
+	lost  uint // Messages of killed workers not returned to the queue
 	mu_p  float64
 	// Beta needs a concurrency primitive
 	beta      uint
 	betaMutex sync.Mutex
 
-	// Derivative keep, also needing a concurrency primitive
-	dx, dy     uint
+	// Derivative keep, also needing a concurrency primitive; dr are the
+	// messages requeued
+	dx, dy, dr uint
 	dTimestamp time.Time
 	dMutex     sync.Mutex
 
 	// Settings
+	percentile      float64
 	mu_p0, sigma_p0 uint
 	unit            time.Duration
+	clock           clock.Clock
+	termination     Termination
 }
 
 func NewManager(mu_p0, sigma_p0 uint, unit time.Duration) *Manager {
@@ -41,12 +55,17 @@ func NewManager(mu_p0, sigma_p0 uint, unit time.Duration) *Manager {
 		newMessage: make(chan struct{}),
 
 		processed: make(chan uint),
+		requeue:   make(chan struct{}),
+		stopped:   make(chan struct{}),
 
 		workers:  []*Worker{},
 		queue:    NewQueue(),
 		mu_p0:    mu_p0,
 		sigma_p0: sigma_p0,
 		unit:     unit,
+		clock:    clock.Real{},
+
+		percentile: defaultPercentile,
 
 		dTimestamp: time.Now(),
 	}
@@ -65,12 +84,13 @@ func (m *Manager) run() {
 		case p, ok := <-m.newMessage:
 			if !ok {
 				close(m.queue.Send)
+				close(m.stopped)
 				return
 			}
 			m.queue.Send <- p
-			m.x++
 
 			m.dMutex.Lock()
+			m.x++
 			m.dx++
 			m.dMutex.Unlock()
 
@@ -80,20 +100,48 @@ func (m *Manager) run() {
 
 			nmu_p *= float64(m.y)
 			nmu_p += float64(d)
-			m.y++
-			nmu_p /= float64(m.y)
+			nmu_p /= float64(m.y + 1)
 
 			// Changed atomically so to avoid concurrency issues
 			m.mu_p = nmu_p
 
 			m.dMutex.Lock()
+			m.y++
 			m.dy++
 			m.dMutex.Unlock()
 
+		case <-m.requeue:
+			m.queue.Send <- struct{}{}
+
+			m.dMutex.Lock()
+			m.dr++
+			m.dMutex.Unlock()
 		}
 	}
 }
 
+// Use clk instead of the wall clock, for the manager and its workers. Must be
+// called before any workers are started.
+func (m *Manager) SetClock(clk clock.Clock) {
+	m.dMutex.Lock()
+	m.clock = clk
+	m.dTimestamp = clk.Now()
+	m.dMutex.Unlock()
+	m.queue.setClock(clk)
+}
+
+// How workers stop when scaling in. By default, they finish their message
+// however long it takes. Must be called before any workers are stopped.
+func (m *Manager) SetTermination(t Termination) {
+	m.termination = t
+}
+
+// Percentile of the time in queue reported on every sample, 0.95 by default.
+// Must be called before sampling.
+func (m *Manager) SetLatencyPercentile(p float64) {
+	m.percentile = p
+}
+
 func (m *Manager) SetB() chan float64 {
 	return m.setB
 }
@@ -102,8 +150,14 @@ func (m *Manager) Message() chan struct{} {
 	return m.newMessage
 }
 
+// Add messages to the queue at once, counting them as arrived.
 func (m *Manager) Add(messages uint) {
 	m.queue.Add(messages)
+
+	m.dMutex.Lock()
+	m.x += messages
+	m.dx += messages
+	m.dMutex.Unlock()
 }
 
 func (m *Manager) setBeta(beta uint) {
@@ -116,54 +170,155 @@ func (m *Manager) setBeta(beta uint) {
 	go func() {
 		m.workersMutex.Lock()
 		for len(m.workers) > int(beta) {
-			go m.workers[len(m.workers)-1].Kill()
+			w := m.workers[len(m.workers)-1]
 			m.workers = m.workers[:len(m.workers)-1]
+			m.draining = append(m.draining, w)
+			go m.stop(w)
 		}
 		for len(m.workers) < int(beta) {
-			m.workers = append(m.workers, NewWorker(m.queue, m.processed, m.mu_p0, m.sigma_p0, m.unit))
+			m.workers = append(m.workers, NewWorkerWithClock(m.queue, m.processed, m.mu_p0, m.sigma_p0, m.unit, m.clock))
 		}
 		m.workersMutex.Unlock()
 	}()
 
 }
 
+// Drain worker w, killing it once the stop timeout is up.
+func (m *Manager) stop(w *Worker) {
+	w.Drain()
+
+	t := m.termination
+	if t.StopTimeout > 0 {
+		timer := m.clock.NewTimer(t.StopTimeout)
+		select {
+		case <-w.Done():
+			timer.Stop()
+		case <-timer.C():
+			w.Kill()
+			if w.Interrupted() {
+				m.dropped(t)
+			}
+		}
+	}
+	<-w.Done()
+
+	m.workersMutex.Lock()
+	for i := range m.draining {
+		if m.draining[i] == w {
+			m.draining = append(m.draining[:i], m.draining[i+1:]...)
+			break
+		}
+	}
+	m.workersMutex.Unlock()
+}
+
+// Handle the message of a worker killed while processing it.
+func (m *Manager) dropped(t Termination) {
+	if !t.Requeue {
+		m.dMutex.Lock()
+		m.lost++
+		m.dMutex.Unlock()
+		return
+	}
+
+	go func() {
+		m.clock.Sleep(t.VisibilityTimeout)
+		select {
+		case m.requeue <- struct{}{}:
+		case <-m.stopped:
+		}
+	}()
+}
+
 func (m *Manager) DXY(unit time.Duration) (dx, dy float64) {
+	dx, dy, _ = m.derivatives(unit)
+	return
+}
+
+// Input, output and requeue rates since the last call.
+func (m *Manager) derivatives(unit time.Duration) (dx, dy, dr float64) {
 	m.dMutex.Lock()
-	now := time.Now()
+	now := m.clock.Now()
 	elapsed := now.Sub(m.dTimestamp)
 	m.dTimestamp = now
 
 	elapsed /= unit
 	dx, dy = float64(m.dx)/float64(elapsed), float64(m.dy)/float64(elapsed)
+	dr = float64(m.dr) / float64(elapsed)
 
-	m.dx, m.dy = 0, 0
+	m.dx, m.dy, m.dr = 0, 0, 0
 	m.dMutex.Unlock()
 
 	return
 }
 
+func (m *Manager) Sample(unit time.Duration) (control.Observation, error) {
+	dx, dy, dr := m.derivatives(unit)
+	return control.Observation{
+		DX:   dx,
+		DY:   dy,
+		DR:   dr,
+		XmY:  m.XmY(),
+		Q:    m.Q(),
+		Beta: m.Beta(),
+		Age:  m.Age(unit),
+	}, nil
+}
+
+// Time messages spend in the queue, in units.
+func (m *Manager) Age(unit time.Duration) *control.QueueAge {
+	oldest, waits := m.queue.ages()
+
+	samples := make([]float64, len(waits))
+	for i, w := range waits {
+		samples[i] = float64(w) / float64(unit)
+	}
+	return &control.QueueAge{
+		Oldest:     float64(oldest) / float64(unit),
+		Latency:    percentile(samples, m.percentile),
+		Percentile: m.percentile,
+	}
+}
+
 func (m *Manager) X() uint {
+	m.dMutex.Lock()
+	defer m.dMutex.Unlock()
 	return m.x
 }
 
 func (m *Manager) Y() uint {
+	m.dMutex.Lock()
+	defer m.dMutex.Unlock()
 	return m.y
 }
 
 func (m *Manager) XmY() uint {
-	return m.x - m.y
+	m.dMutex.Lock()
+	defer m.dMutex.Unlock()
+	return m.x - m.y - m.lost
+}
+
+// Messages of workers killed while draining that weren't returned to the
+// queue.
+func (m *Manager) Lost() uint {
+	m.dMutex.Lock()
+	defer m.dMutex.Unlock()
+	return m.lost
 }
 
 func (m *Manager) MuP() (float64, bool) {
 	return m.mu_p, true
 }
 
+// Workers running, including those draining.
 func (m *Manager) Beta() uint {
-	return uint(len(m.workers))
+	m.workersMutex.Lock()
+	defer m.workersMutex.Unlock()
+	return uint(len(m.workers) + len(m.draining))
 }
 
 func (m *Manager) Q() uint {
-	return m.queue.pending
+	return m.queue.depth()
 }
 
 func bToBeta(b float64) uint {
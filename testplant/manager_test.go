@@ -0,0 +1,88 @@
+package testplant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+)
+
+// Wait for cond to hold, as the manager's workers run on their own.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestManagerTermination(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		requeue  bool
+		q, xmy   uint
+		lost, dr uint
+	}{
+		{"requeue", true, 19, 20, 0, 3},
+		{"lost", false, 16, 17, 3, 0},
+	} {
+		// Messages take about 20 seconds to process.
+		clk := clock.NewFake(time.Unix(0, 0))
+		m := NewManager(10, 0, time.Millisecond)
+		m.SetClock(clk)
+		m.SetTermination(Termination{StopTimeout: time.Second, Requeue: tc.requeue})
+		m.Add(20)
+
+		m.SetB() <- 4
+		clk.BlockUntil(4)
+		eventually(t, "4 messages taken", func() bool { return m.Q() == 16 })
+
+		// Workers draining still count as running, until they're killed.
+		m.SetB() <- 1
+		clk.BlockUntil(7)
+		if beta := m.Beta(); beta != 4 {
+			t.Errorf("%s: expected 4 workers while draining, got %d", tc.name, beta)
+		}
+
+		clk.Advance(time.Second)
+		eventually(t, "workers to be killed", func() bool {
+			return m.Beta() == 1 && m.Q() == tc.q
+		})
+		if xmy, lost := m.XmY(), m.Lost(); xmy != tc.xmy || lost != tc.lost {
+			t.Errorf("%s: expected %d messages in the system and %d lost, got %d and %d", tc.name, tc.xmy, tc.lost, xmy, lost)
+		}
+		if _, _, dr := m.derivatives(time.Second); dr != float64(tc.dr) {
+			t.Errorf("%s: expected %d messages requeued, got %v per second", tc.name, tc.dr, dr)
+		}
+		close(m.Message())
+	}
+}
+
+func TestQueueKeepsLastWaits(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	q := &Queue{clock: clk}
+
+	// Nobody samples the queue while more than maxWaits messages go through
+	// it, each waiting a second longer than the last.
+	n := maxWaits + 10
+	q.enqueue(uint(n))
+	for i := 0; i < n; i++ {
+		clk.Advance(time.Second)
+		q.dequeue()
+	}
+
+	_, waits := q.ages()
+	if len(waits) != maxWaits {
+		t.Fatalf("expected %d waits, got %d", maxWaits, len(waits))
+	}
+	for _, w := range waits {
+		if w <= 10*time.Second {
+			t.Fatalf("expected the first 10 waits overwritten, got %v", w)
+		}
+	}
+	if _, waits = q.ages(); len(waits) != 0 {
+		t.Errorf("expected waits drained, got %d", len(waits))
+	}
+}
@@ -1,16 +1,37 @@
 package testplant
 
+import (
+	"sync"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+)
+
+// Waits kept between calls to ages. Older ones are overwritten, so that a
+// queue nobody samples doesn't grow without bound.
+const maxWaits = 10000
+
 type Queue struct {
 	// Since messages are empty, we can just use an integer for the pending ones
 	pending uint
 
 	Send, Recv chan struct{}
+
+	// When every pending message was queued up, and how long the messages
+	// received since the last call to ages waited, up to maxWaits of them.
+	// Once full, waits is a ring with the next one to overwrite at next.
+	queued []time.Time
+	waits  []time.Duration
+	next   int
+	clock  clock.Clock
+	mu     sync.Mutex
 }
 
 func NewQueue() *Queue {
 	q := &Queue{
-		Send: make(chan struct{}),
-		Recv: make(chan struct{}),
+		Send:  make(chan struct{}),
+		Recv:  make(chan struct{}),
+		clock: clock.Real{},
 	}
 
 	// Consumer/producer/waiter
@@ -18,15 +39,15 @@ func NewQueue() *Queue {
 	go func() {
 		for {
 			// If there are values in queue, accept reads and writes
-			if q.pending > 0 {
+			if q.depth() > 0 {
 				select {
 				case _, ok := <-q.Send:
 					if !ok {
 						return // Finish
 					}
-					q.pending++
+					q.enqueue(1)
 				case q.Recv <- *new(struct{}):
-					q.pending--
+					q.dequeue()
 				}
 			} else {
 				// Wait until we've received
@@ -34,7 +55,7 @@ func NewQueue() *Queue {
 				if !ok {
 					return
 				}
-				q.pending++
+				q.enqueue(1)
 			}
 		}
 	}()
@@ -43,6 +64,64 @@ func NewQueue() *Queue {
 
 }
 
+// Add messages to the queue. Goes through Send, so it mustn't be closed.
 func (q *Queue) Add(messages uint) {
+	for i := uint(0); i < messages; i++ {
+		q.Send <- struct{}{}
+	}
+}
+
+// Messages pending.
+func (q *Queue) depth() uint {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending
+}
+
+func (q *Queue) setClock(clk clock.Clock) {
+	q.mu.Lock()
+	q.clock = clk
+	q.mu.Unlock()
+}
+
+func (q *Queue) enqueue(messages uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	q.pending += messages
+	now := q.clock.Now()
+	for i := uint(0); i < messages; i++ {
+		q.queued = append(q.queued, now)
+	}
+}
+
+func (q *Queue) dequeue() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending--
+	if len(q.queued) == 0 {
+		return
+	}
+	wait := q.clock.Since(q.queued[0])
+	if len(q.waits) < maxWaits {
+		q.waits = append(q.waits, wait)
+	} else {
+		q.waits[q.next] = wait
+		q.next = (q.next + 1) % maxWaits
+	}
+	q.queued = q.queued[1:]
+}
+
+// Age of the oldest message pending, and how long the messages received since
+// the last call waited, in no particular order and at most the last maxWaits.
+func (q *Queue) ages() (oldest time.Duration, waits []time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.queued) > 0 {
+		oldest = q.clock.Since(q.queued[0])
+	}
+	waits, q.waits, q.next = q.waits, nil, 0
+	return oldest, waits
 }
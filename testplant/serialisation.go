@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
 )
 
 // implements Plant
@@ -12,6 +14,7 @@ type Serialiser struct {
 	receive  chan struct{}
 	filename string
 	start    time.Time
+	clock    clock.Clock
 
 	file    *os.File
 	encoder *gob.Encoder
@@ -21,9 +24,15 @@ func NewSerialiser(filename string) *Serialiser {
 	return &Serialiser{
 		receive:  nil, // Wait to be started
 		filename: filename,
+		clock:    clock.Real{},
 	}
 }
 
+// Use clk instead of the wall clock. Must be called before Start.
+func (s *Serialiser) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
 func (s *Serialiser) Start(initial uint) error {
 	var err error
 
@@ -35,7 +44,7 @@ func (s *Serialiser) Start(initial uint) error {
 	s.encoder.Encode(initial)
 
 	s.receive = make(chan struct{})
-	s.start = time.Now()
+	s.start = s.clock.Now()
 	go s.run()
 
 	return nil
@@ -54,8 +63,8 @@ func (s *Serialiser) run() {
 	t1 := s.start
 	var d time.Duration
 	for range s.receive {
-		d = time.Since(t1)
-		t1 = time.Now()
+		d = s.clock.Since(t1)
+		t1 = s.clock.Now()
 		s.encoder.Encode(d)
 	}
 }
@@ -67,6 +76,7 @@ type Deserialiser struct {
 	receiver chan struct{}
 	done     chan bool
 	initial  uint
+	clock    clock.Clock
 }
 
 func NewDeserialiser(filename string) (*Deserialiser, error) {
@@ -79,6 +89,7 @@ func NewDeserialiser(filename string) (*Deserialiser, error) {
 		file:    f,
 		decoder: gob.NewDecoder(f),
 		done:    make(chan bool),
+		clock:   clock.Real{},
 	}
 	if err = d.decoder.Decode(&d.initial); err != nil {
 		return nil, fmt.Errorf("Cannot decode initial value: %s", err)
@@ -86,6 +97,11 @@ func NewDeserialiser(filename string) (*Deserialiser, error) {
 	return d, nil
 }
 
+// Use clk instead of the wall clock. Must be called before Start.
+func (d *Deserialiser) SetClock(clk clock.Clock) {
+	d.clock = clk
+}
+
 func (d *Deserialiser) Start(receiver chan struct{}) {
 	d.receiver = receiver
 	go d.run()
@@ -94,7 +110,7 @@ func (d *Deserialiser) Start(receiver chan struct{}) {
 func (d *Deserialiser) run() {
 	var val time.Duration
 	for d.decoder.Decode(&val) == nil {
-		time.Sleep(val)
+		d.clock.Sleep(val)
 		d.receiver <- *new(struct{})
 	}
 	d.file.Close()
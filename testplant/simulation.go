@@ -0,0 +1,492 @@
+package testplant
+
+import (
+	"container/heap"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Discrete-event simulation of the plant, implementing control.Manager. It
+// uses the same arrival and processing models as the Generator and Worker, but
+// time is virtual and jumps from event to event, so hours of traffic are
+// simulated in a fraction of a second. Given the same seed, a simulation is
+// reproducible.
+type Simulation struct {
+	setB chan float64
+
+	// Arrivals and processing times are drawn from separate streams, so
+	// that every controller sees the same traffic for the same seed.
+	arrivals, processing *rand.Rand
+
+	clock  *clock.Fake
+	start  time.Time
+	now    time.Duration // Since start
+	events eventQueue
+	seq    uint64 // For ordering simultaneous events
+
+	// Workers
+	workers  []*simWorker // Running, last ones are stopped first
+	idle     []*simWorker
+	starting []*simWorker // Workers yet to start, last ones cancelled first
+	draining []*simWorker // Stopped, finishing their message
+
+	// Messages queued up, by arrival time
+	queue []time.Duration
+
+	// Time queued up of the messages dispatched since the last sample, in
+	// units, and the percentile of it reported
+	waits      []float64
+	percentile float64
+
+	x, y uint
+	lost uint
+	mu_p float64
+
+	// Derivatives since the last sample, and messages requeued
+	dx, dy, dr uint
+	dTimestamp time.Duration
+
+	stats SimulationStats
+
+	// Settings
+	logMu, logSigma float64
+	mu_p0, sigma_p0 uint
+	unit            time.Duration
+	startDelay      time.Duration
+	termination     Termination
+}
+
+// Results of a simulation. Times are given in units.
+type SimulationStats struct {
+	X, Y        uint
+	MaxQ        uint
+	MeanQ       float64 // Average over time
+	MeanWait    float64 // Average time in queue of processed messages
+	MaxWait     float64
+	WorkerUnits float64 // Running workers integrated over time
+	Duration    float64
+
+	// Messages of workers killed while draining, returned to the queue or
+	// lost
+	Requeued, Lost uint
+
+	qIntegral, waitTotal float64
+}
+
+type simWorker struct {
+	stopped  bool
+	draining bool
+
+	// Arrival of the message being processed
+	arrival time.Duration
+}
+
+const (
+	arrivalEvent = iota
+	completionEvent
+	workerStartEvent
+	controlEvent
+	burstEvent
+	logMuEvent
+	killEvent
+	requeueEvent
+)
+
+type event struct {
+	at   time.Duration
+	seq  uint64
+	kind int
+
+	worker *simWorker
+
+	// Bursts and arrival rate changes, processing time for completions,
+	// arrival of messages requeued
+	logMu   float64
+	size    uint
+	arrival time.Duration
+}
+
+func NewSimulation(logMu, logSigma float64, mu_p0, sigma_p0 uint, unit time.Duration, seed int64) *Simulation {
+	start := time.Unix(0, 0)
+	seeds := rand.New(rand.NewSource(seed))
+	s := &Simulation{
+		setB:       make(chan float64, 1),
+		arrivals:   rand.New(rand.NewSource(seeds.Int63())),
+		processing: rand.New(rand.NewSource(seeds.Int63())),
+		clock:      clock.NewFake(start),
+		start:      start,
+		logMu:      logMu,
+		logSigma:   logSigma,
+		mu_p0:      mu_p0,
+		sigma_p0:   sigma_p0,
+		unit:       unit,
+
+		percentile: defaultPercentile,
+	}
+
+	s.schedule(s.arrivalDelay(s.logMu), &event{kind: arrivalEvent})
+
+	return s
+}
+
+// Delay between a worker being requested and it starting to process messages.
+func (s *Simulation) SetStartDelay(d time.Duration) {
+	s.startDelay = d
+}
+
+// How workers stop when scaling in. By default, they finish their message
+// however long it takes.
+func (s *Simulation) SetTermination(t Termination) {
+	s.termination = t
+}
+
+// Percentile of the time in queue reported on every sample, 0.95 by default.
+func (s *Simulation) SetLatencyPercentile(p float64) {
+	s.percentile = p
+}
+
+// Virtual clock of the simulation, to be shared with the controller.
+func (s *Simulation) Clock() clock.Clock {
+	return s.clock
+}
+
+// Add messages to the queue, as Manager.Add does.
+func (s *Simulation) Add(messages uint) {
+	for i := uint(0); i < messages; i++ {
+		s.queue = append(s.queue, s.now)
+	}
+	s.x += messages
+	s.dx += messages
+	s.dispatch()
+}
+
+// Schedule a burst of size messages at a given time since the start of the
+// simulation, as Generator.Burst does.
+func (s *Simulation) Burst(at time.Duration, relMu, size uint) {
+	s.schedule(at-s.now, &event{
+		kind:  burstEvent,
+		logMu: s.logMu - float64(relMu),
+		size:  size,
+	})
+}
+
+// Change the arrival rate at a given time since the start of the simulation,
+// as Generator.IncreaseLogMu does.
+func (s *Simulation) IncreaseLogMu(at time.Duration, delta float64) {
+	s.schedule(at-s.now, &event{kind: logMuEvent, logMu: delta})
+}
+
+// Run the simulation for d, stepping ctl every control period. Without ctl,
+// only the betas sent through SetB are applied, every control period. Returns
+// the stats since the start of the simulation.
+func (s *Simulation) Run(d, period time.Duration, ctl control.Controller) SimulationStats {
+	end := s.now + d
+	s.schedule(period, &event{kind: controlEvent})
+
+	for s.events.Len() > 0 {
+		if s.events[0].at > end {
+			break
+		}
+		ev := heap.Pop(&s.events).(*event)
+		s.advance(ev.at)
+
+		switch ev.kind {
+		case arrivalEvent:
+			s.arrive()
+			s.schedule(s.arrivalDelay(s.logMu), ev)
+
+		case burstEvent:
+			if ev.size == 0 {
+				break
+			}
+			s.arrive()
+			ev.size--
+			s.schedule(s.arrivalDelay(ev.logMu), ev)
+
+		case logMuEvent:
+			s.logMu += ev.logMu
+
+		case completionEvent:
+			s.complete(ev.worker, ev.size)
+
+		case workerStartEvent:
+			w := ev.worker
+			if w.stopped {
+				// Cancelled
+				break
+			}
+			s.starting = removeWorker(s.starting, w)
+			s.workers = append(s.workers, w)
+			s.idle = append(s.idle, w)
+			s.dispatch()
+
+		case killEvent:
+			s.kill(ev.worker)
+
+		case requeueEvent:
+			s.requeue(ev.arrival)
+
+		case controlEvent:
+			s.control(ctl)
+			s.schedule(period, ev)
+		}
+	}
+	s.advance(end)
+
+	// Control events are rescheduled on every run.
+	for i := 0; i < s.events.Len(); i++ {
+		if s.events[i].kind == controlEvent {
+			heap.Remove(&s.events, i)
+			break
+		}
+	}
+
+	stats := s.stats
+	stats.X, stats.Y = s.x, s.y
+	stats.Duration = float64(s.now) / float64(s.unit)
+	if stats.Duration > 0 {
+		stats.MeanQ = stats.qIntegral / stats.Duration
+	}
+	if stats.Y > 0 {
+		stats.MeanWait = stats.waitTotal / float64(stats.Y)
+	}
+	return stats
+}
+
+func (s *Simulation) schedule(after time.Duration, ev *event) {
+	ev.at = s.now + after
+	ev.seq = s.seq
+	s.seq++
+	heap.Push(&s.events, ev)
+}
+
+// Move the virtual time forward, integrating the stats on the way.
+func (s *Simulation) advance(to time.Duration) {
+	elapsed := float64(to-s.now) / float64(s.unit)
+	s.stats.qIntegral += float64(len(s.queue)) * elapsed
+	s.stats.WorkerUnits += float64(len(s.workers)+len(s.draining)) * elapsed
+
+	s.clock.Advance(to - s.now)
+	s.now = to
+}
+
+func (s *Simulation) arrivalDelay(logMu float64) time.Duration {
+	return logDuration(s.arrivals.NormFloat64(), logMu, s.logSigma, s.unit)
+}
+
+func (s *Simulation) arrive() {
+	s.queue = append(s.queue, s.now)
+	s.x++
+	s.dx++
+	if q := uint(len(s.queue)); q > s.stats.MaxQ {
+		s.stats.MaxQ = q
+	}
+	s.dispatch()
+}
+
+// Hand queued messages to idle workers.
+func (s *Simulation) dispatch() {
+	for len(s.queue) > 0 && len(s.idle) > 0 {
+		w := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+
+		w.arrival = s.queue[0]
+		wait := float64(s.now-s.queue[0]) / float64(s.unit)
+		s.queue = s.queue[1:]
+		s.waits = append(s.waits, wait)
+		s.stats.waitTotal += wait
+		if wait > s.stats.MaxWait {
+			s.stats.MaxWait = wait
+		}
+
+		d := processTime(s.processing.NormFloat64(), s.mu_p0, s.sigma_p0)
+		s.schedule(time.Duration(d)*s.unit, &event{
+			kind:   completionEvent,
+			worker: w,
+			size:   d,
+		})
+	}
+}
+
+// Worker w is done with a message that took d units to process.
+func (s *Simulation) complete(w *simWorker, d uint) {
+	if w.stopped {
+		// Killed before it was done
+		return
+	}
+
+	// Update median and total, as the Manager does
+	nmu_p := s.mu_p * float64(s.y)
+	nmu_p += float64(d)
+	s.y++
+	s.mu_p = nmu_p / float64(s.y)
+	s.dy++
+	if w.draining {
+		s.draining = removeWorker(s.draining, w)
+		w.draining, w.stopped = false, true
+		return
+	}
+	s.idle = append(s.idle, w)
+	s.dispatch()
+}
+
+// Kill worker w if it's still draining once its stop timeout is up.
+func (s *Simulation) kill(w *simWorker) {
+	if !w.draining {
+		return
+	}
+	s.draining = removeWorker(s.draining, w)
+	w.draining, w.stopped = false, true
+
+	if !s.termination.Requeue {
+		s.lost++
+		s.stats.Lost++
+		return
+	}
+	s.schedule(s.termination.VisibilityTimeout, &event{kind: requeueEvent, arrival: w.arrival})
+}
+
+// Return a message that arrived at arrival to the queue, as a failed attempt.
+func (s *Simulation) requeue(arrival time.Duration) {
+	i := sort.Search(len(s.queue), func(i int) bool { return s.queue[i] > arrival })
+	s.queue = append(s.queue, 0)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = arrival
+
+	s.dr++
+	s.stats.Requeued++
+	if q := uint(len(s.queue)); q > s.stats.MaxQ {
+		s.stats.MaxQ = q
+	}
+	s.dispatch()
+}
+
+func (s *Simulation) control(ctl control.Controller) {
+	// Betas might also be sent through SetB, apply the latest.
+	select {
+	case b := <-s.setB:
+		s.setBeta(bToBeta(b))
+	default:
+	}
+
+	if ctl == nil {
+		return
+	}
+
+	// Failing controllers might still set a failsafe beta.
+	if b, set, _ := ctl.Step(); set {
+		s.setBeta(bToBeta(b))
+	}
+}
+
+func (s *Simulation) setBeta(beta uint) {
+	// Cancel workers yet to start first.
+	for len(s.starting) > 0 && uint(len(s.workers)+len(s.starting)) > beta {
+		w := s.starting[len(s.starting)-1]
+		s.starting = s.starting[:len(s.starting)-1]
+		w.stopped = true
+	}
+
+	// Then stop the last workers. Idle ones stop at once, busy ones drain.
+	for uint(len(s.workers)) > beta {
+		w := s.workers[len(s.workers)-1]
+		s.workers = s.workers[:len(s.workers)-1]
+		idle := len(s.idle)
+		s.idle = removeWorker(s.idle, w)
+		if len(s.idle) < idle {
+			w.stopped = true
+			continue
+		}
+		w.draining = true
+		s.draining = append(s.draining, w)
+		if s.termination.StopTimeout > 0 {
+			s.schedule(s.termination.StopTimeout, &event{kind: killEvent, worker: w})
+		}
+	}
+
+	for uint(len(s.workers)+len(s.starting)) < beta {
+		w := &simWorker{}
+		s.starting = append(s.starting, w)
+		s.schedule(s.startDelay, &event{kind: workerStartEvent, worker: w})
+	}
+}
+
+func removeWorker(workers []*simWorker, w *simWorker) []*simWorker {
+	for i := range workers {
+		if workers[i] == w {
+			return append(workers[:i], workers[i+1:]...)
+		}
+	}
+	return workers
+}
+
+func (s *Simulation) SetB() chan float64 {
+	return s.setB
+}
+
+func (s *Simulation) Sample(unit time.Duration) (control.Observation, error) {
+	elapsed := float64(s.now-s.dTimestamp) / float64(unit)
+	s.dTimestamp = s.now
+
+	var dx, dy, dr float64
+	if elapsed > 0 {
+		dx, dy, dr = float64(s.dx)/elapsed, float64(s.dy)/elapsed, float64(s.dr)/elapsed
+	}
+	s.dx, s.dy, s.dr = 0, 0, 0
+
+	age := &control.QueueAge{Percentile: s.percentile}
+	if len(s.queue) > 0 {
+		age.Oldest = float64(s.now-s.queue[0]) / float64(unit)
+	}
+	age.Latency = percentile(s.waits, s.percentile) * float64(s.unit) / float64(unit)
+	s.waits = s.waits[:0]
+
+	return control.Observation{
+		DX:      dx,
+		DY:      dy,
+		DR:      dr,
+		XmY:     s.x - s.y - s.lost,
+		Q:       uint(len(s.queue)),
+		Beta:    uint(len(s.workers) + len(s.draining)),
+		Desired: uint(len(s.workers) + len(s.starting)),
+		Pending: uint(len(s.starting)),
+		Age:     age,
+	}, nil
+}
+
+func (s *Simulation) MuP() (float64, bool) {
+	return s.mu_p, true
+}
+
+// Priority queue of events, by time and then by order of scheduling.
+type eventQueue []*event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at < q[j].at
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *eventQueue) Push(x any) {
+	*q = append(*q, x.(*event))
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
@@ -0,0 +1,205 @@
+package testplant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lowercases/queue-scaling/control"
+)
+
+// Simulate a day of traffic doubling at midday, controlled with maxQueueTime
+// seconds, and a latency SLO in seconds unless zero.
+func simulateDay(seed int64, maxQueueTime, slo uint) SimulationStats {
+	// About two messages per second, processed in a second and a bit each.
+	sim := NewSimulation(6, 0.5, 7, 0, time.Millisecond, seed)
+	sim.SetStartDelay(30 * time.Second)
+	sim.IncreaseLogMu(12*time.Hour, -0.7)
+
+	c := control.NewControl(sim, 10, maxQueueTime, time.Second)
+	c.SetClock(sim.Clock())
+	if slo > 0 {
+		c.SetLatencySLO(slo)
+	}
+
+	return sim.Run(24*time.Hour, 10*time.Second, c)
+}
+
+func TestSimulationIsReproducible(t *testing.T) {
+	a, b := simulateDay(1, 60, 0), simulateDay(1, 60, 0)
+	if a != b {
+		t.Errorf("expected equal stats for equal seeds, got %+v and %+v", a, b)
+	}
+	if a.Y == 0 || a.X-a.Y > a.MaxQ+100 {
+		t.Errorf("expected the plant to keep up with the messages, got %+v", a)
+	}
+}
+
+func TestSimulationSweep(t *testing.T) {
+	var prev SimulationStats
+	for i, mq := range []uint{15, 60, 240} {
+		stats := simulateDay(2, mq, 0)
+		t.Logf("maxQueueTime %ds: mean wait %.0fms, max %.0fms, %.1f worker hours",
+			mq, stats.MeanWait, stats.MaxWait, stats.WorkerUnits/float64(time.Hour/time.Millisecond))
+
+		if i > 0 && stats.WorkerUnits > prev.WorkerUnits*1.1 {
+			t.Errorf("maxQueueTime %ds: expected fewer workers than for a tighter maxQueueTime", mq)
+		}
+		prev = stats
+	}
+}
+
+func TestSimulationLatencySLO(t *testing.T) {
+	// The max wait is set by the cold start, which no SLO can help with.
+	loose, slo := simulateDay(2, 240, 0), simulateDay(2, 240, 30)
+	t.Logf("mean wait %.0fms without SLO, %.0fms with a 30s SLO", loose.MeanWait, slo.MeanWait)
+
+	if slo.MeanWait > loose.MeanWait/2 {
+		t.Errorf("expected the mean wait at least halved with an SLO, got %.0fms and %.0fms", loose.MeanWait, slo.MeanWait)
+	}
+}
+
+func TestSimulationReportsAge(t *testing.T) {
+	sim := NewSimulation(6, 0.5, 7, 0, time.Millisecond, 3)
+	sim.Add(10)
+	// No controller, so control periods go by without stepping any.
+	sim.Run(time.Minute, time.Second, nil)
+
+	obs, _ := sim.Sample(time.Second)
+	if obs.Age == nil || obs.Age.Percentile != 0.95 {
+		t.Fatalf("expected the age of messages, got %+v", obs.Age)
+	}
+	// Without workers, the messages added at the start are the oldest.
+	if obs.Age.Oldest != 60 || obs.Age.Latency != 0 {
+		t.Errorf("expected the oldest message to be a minute old, got %+v", *obs.Age)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	samples := []float64{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+	if p := percentile(samples, 0.95); p != 10 {
+		t.Errorf("expected a p95 of 10, got %v", p)
+	}
+	if p := percentile(samples, 0.5); p != 5 {
+		t.Errorf("expected a median of 5, got %v", p)
+	}
+	if p := percentile(nil, 0.95); p != 0 {
+		t.Errorf("expected 0 without samples, got %v", p)
+	}
+}
+
+// Control, a PID and a model predictive controller on the same traffic.
+// Control and the MPC keep messages well under the max queue time, while the
+// PID controller tracks it.
+func TestSimulationControllersAB(t *testing.T) {
+	run := func(name string) SimulationStats {
+		sim := NewSimulation(6, 0.5, 7, 0, time.Millisecond, 4)
+		sim.SetStartDelay(30 * time.Second)
+		sim.IncreaseLogMu(12*time.Hour, -0.7)
+
+		var ctl control.Controller
+		switch name {
+		case "model":
+			c := control.NewControl(sim, 10, 60, time.Second)
+			c.SetClock(sim.Clock())
+			ctl = c
+		case "pid":
+			p := control.NewPID(sim, 10, 60, time.Second, 0.05, 0.001, 0)
+			p.SetClock(sim.Clock())
+			ctl = p
+		case "mpc":
+			m := control.NewMPC(sim, 10, 60, time.Second)
+			m.SetClock(sim.Clock())
+			ctl = m
+		}
+		return sim.Run(24*time.Hour, 10*time.Second, ctl)
+	}
+
+	stats := map[string]SimulationStats{}
+	for _, name := range []string{"model", "pid", "mpc"} {
+		s := run(name)
+		t.Logf("%s: mean wait %.0fms, max %.0fms, %.1f worker hours", name,
+			s.MeanWait, s.MaxWait, s.WorkerUnits/float64(time.Hour/time.Millisecond))
+		if s.X-s.Y > s.MaxQ+100 {
+			t.Errorf("%s: expected the plant to keep up with the messages, got %+v", name, s)
+		}
+		if s.X != stats["model"].X && name != "model" {
+			t.Errorf("%s: expected the same arrivals as for Control, got %d and %d", name, s.X, stats["model"].X)
+		}
+		stats[name] = s
+	}
+
+	if pid := stats["pid"]; pid.MeanWait < 45000 || pid.MeanWait > 75000 {
+		t.Errorf("expected the PID controller to keep the mean wait around a minute, got %.0fms", pid.MeanWait)
+	}
+	if mpc := stats["mpc"]; mpc.MeanWait > 30000 {
+		t.Errorf("expected the MPC to keep the mean wait well under a minute, got %.0fms", mpc.MeanWait)
+	}
+}
+
+// Workers take three minutes to start, while traffic ramps up fourfold. The
+// delay is learnt, and the workers requested while others are on their way
+// don't overshoot.
+func TestSimulationProvisioningDelay(t *testing.T) {
+	sim := NewSimulation(6, 0.5, 7, 0, time.Millisecond, 5)
+	sim.SetStartDelay(3 * time.Minute)
+	sim.IncreaseLogMu(2*time.Hour, -1.5)
+
+	c := control.NewControl(sim, 10, 60, time.Second)
+	c.SetClock(sim.Clock())
+	var peak float64
+	c.AddSink(control.SinkFunc(func(d control.Decision) {
+		if d.Set && d.Beta > peak {
+			peak = d.Beta
+		}
+	}))
+
+	stats := sim.Run(4*time.Hour, 10*time.Second, c)
+	t.Logf("mean wait %.0fms, peak beta %.1f, final %.1f", stats.MeanWait, peak, c.Beta())
+
+	if d := c.ProvisioningDelay(); d < 170 || d > 190 {
+		t.Errorf("expected a provisioning delay of about 180s learnt, got %v", d)
+	}
+	// Without compensation, the peak is about 40.
+	if peak > 3*c.Beta() {
+		t.Errorf("expected beta not to overshoot, got a peak of %v for %v", peak, c.Beta())
+	}
+	if stats.X-stats.Y > stats.MaxQ+100 {
+		t.Errorf("expected the plant to keep up with the messages, got %+v", stats)
+	}
+}
+
+func TestSimulationTermination(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		termination    Termination
+		beta           uint // Right after scaling in
+		requeued, lost uint // Once stopped
+	}{
+		{"graceful", Termination{}, 10, 0, 0},
+		{"requeue", Termination{StopTimeout: time.Second, Requeue: true, VisibilityTimeout: 30 * time.Second}, 2, 8, 0},
+		{"lost", Termination{StopTimeout: time.Second}, 2, 0, 8},
+	} {
+		// Messages take about 20 seconds to process, and none arrive.
+		sim := NewSimulation(20, 0, 10, 0, time.Millisecond, 6)
+		sim.SetTermination(tc.termination)
+		sim.Add(100)
+		sim.setBeta(10)
+		sim.Run(time.Second, time.Hour, nil)
+
+		sim.setBeta(2)
+		sim.Run(2*time.Second, time.Hour, nil)
+		obs, _ := sim.Sample(time.Second)
+		if obs.Beta != tc.beta || obs.Desired != 2 {
+			t.Errorf("%s: expected %d workers running of 2 desired, got %+v", tc.name, tc.beta, obs)
+		}
+
+		stats := sim.Run(time.Minute, time.Hour, nil)
+		obs, _ = sim.Sample(time.Second)
+		if obs.Beta != 2 || stats.Requeued != tc.requeued || stats.Lost != tc.lost || obs.XmY != stats.X-stats.Y-stats.Lost {
+			t.Errorf("%s: expected 2 workers, %d messages requeued and %d lost, got %+v and %+v", tc.name, tc.requeued, tc.lost, obs, stats)
+		}
+		if tc.requeued > 0 && obs.DR == 0 {
+			t.Errorf("%s: expected the messages requeued as failed attempts", tc.name)
+		}
+	}
+}
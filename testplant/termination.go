@@ -0,0 +1,19 @@
+package testplant
+
+import "time"
+
+// How workers stop when the plant is scaled in, as ECS stops tasks: a worker
+// stopped drains, taking no more messages and finishing the one it's got, and
+// keeps counting as running until it's done. The zero value waits for
+// draining workers however long their message takes.
+type Termination struct {
+	// Time a draining worker gets to finish its message before it's killed,
+	// as ECS's stopTimeout; zero for no limit.
+	StopTimeout time.Duration
+
+	// Whether the message of a killed worker is returned to the queue after
+	// VisibilityTimeout, as SQS does with messages not deleted in time, and
+	// counted as a failed attempt. Otherwise it's lost.
+	Requeue           bool
+	VisibilityTimeout time.Duration
+}
@@ -3,22 +3,40 @@ package testplant
 import (
 	"math"
 	"math/rand"
+	"sync"
 	"time"
+
+	"github.com/Lowercases/queue-scaling/clock"
 )
 
 const MAX_P_UNIT = 30000
 
 type Worker struct {
-	done      chan bool
 	queue     *Queue
 	processed chan uint
+	clock     clock.Clock
+
+	// Closed to drain and to kill the worker, and once it's stopped
+	drain, kill, done   chan struct{}
+	drainOnce, killOnce sync.Once
+
+	// Whether it was killed while processing a message
+	interrupted bool
 }
 
 func NewWorker(q *Queue, p chan uint, mu_p0, sigma_p0 uint, unit time.Duration) *Worker {
+	return NewWorkerWithClock(q, p, mu_p0, sigma_p0, unit, clock.Real{})
+}
+
+// Like NewWorker, timing messages with clk instead of the wall clock.
+func NewWorkerWithClock(q *Queue, p chan uint, mu_p0, sigma_p0 uint, unit time.Duration, clk clock.Clock) *Worker {
 	w := &Worker{
 		queue:     q,
 		processed: p,
-		done:      make(chan bool),
+		drain:     make(chan struct{}),
+		kill:      make(chan struct{}),
+		done:      make(chan struct{}),
+		clock:     clk,
 	}
 
 	go w.run(mu_p0, sigma_p0, unit)
@@ -26,30 +44,81 @@ func NewWorker(q *Queue, p chan uint, mu_p0, sigma_p0 uint, unit time.Duration)
 	return w
 }
 
+// Stop taking messages, finishing the current one if any. Done is closed once
+// the worker has stopped.
+func (w *Worker) Drain() {
+	w.drainOnce.Do(func() { close(w.drain) })
+}
+
+// Stop the worker at once, dropping the message it's processing. Returns once
+// it has stopped.
 func (w *Worker) Kill() {
-	w.done <- true
+	w.killOnce.Do(func() { close(w.kill) })
+	<-w.done
+}
+
+// Whether the worker was killed while processing a message. Only meaningful
+// once Done is closed.
+func (w *Worker) Interrupted() bool {
+	select {
+	case <-w.done:
+		return w.interrupted
+	default:
+		return false
+	}
+}
+
+// Closed once the worker has stopped.
+func (w *Worker) Done() <-chan struct{} {
+	return w.done
 }
 
 func (w *Worker) run(mu_p0, sigma_p0 uint, unit time.Duration) {
+	defer close(w.done)
+
 	for {
+		// Draining takes precedence over messages waiting.
+		select {
+		case <-w.drain:
+			return
+		case <-w.kill:
+			return
+		default:
+		}
+
 		select {
-		case <-w.done:
+		case <-w.drain:
+			return
+		case <-w.kill:
 			return
 
 		case <-w.queue.Recv:
-			d := process(mu_p0, sigma_p0, unit)
-			w.processed <- d
+			d := ProcessTime(mu_p0, sigma_p0)
+			// Increase precision for sleep
+			t := w.clock.NewTimer(time.Duration(float64(d) * float64(unit/time.Nanosecond)))
+			select {
+			case <-t.C():
+				w.processed <- d
+			case <-w.kill:
+				t.Stop()
+				w.interrupted = true
+				return
+			}
 		}
 	}
 }
 
-func process(mu_p0, sigma_p0 uint, unit time.Duration) uint {
-	r := uint(math.Exp(rand.NormFloat64()*float64(sigma_p0) + float64(mu_p0)))
+// Processing time of a message, in units, drawn from a log-normal
+// distribution and capped at MAX_P_UNIT.
+func ProcessTime(mu_p0, sigma_p0 uint) uint {
+	return processTime(rand.NormFloat64(), mu_p0, sigma_p0)
+}
+
+// Processing time given a standard normal sample.
+func processTime(norm float64, mu_p0, sigma_p0 uint) uint {
+	r := uint(math.Exp(norm*float64(sigma_p0) + float64(mu_p0)))
 	if r > MAX_P_UNIT {
 		return MAX_P_UNIT
 	}
-	// Increase precision for sleep
-	sl := float64(r) * float64(unit/time.Nanosecond)
-	time.Sleep(time.Duration(sl))
 	return r
 }